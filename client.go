@@ -5,6 +5,7 @@ import (
 
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/cli/account"
+	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/cli/network"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/cli/transaction"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/cli/wallet"
 
@@ -33,6 +34,7 @@ func main() {
 		wallet.NewResetCommand(),
 		account.NewCommand(),
 		transaction.NewCommand(),
+		network.NewCommand(),
 	}
 
 	app.Run(os.Args)
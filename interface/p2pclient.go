@@ -16,6 +16,9 @@ type P2PClient interface {
 	// Start the P2P client
 	Start()
 
+	// Stop the P2P client
+	Stop()
+
 	// Get the peer manager of this P2P client
 	PeerManager() *net.PeerManager
 }
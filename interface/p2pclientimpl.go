@@ -2,8 +2,6 @@ package _interface
 
 import (
 	"github.com/wuyazero/Elastos.ELA.SPV/net"
-
-	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
 )
 
 type P2PClientImpl struct {
@@ -13,12 +11,10 @@ type P2PClientImpl struct {
 }
 
 func (client *P2PClientImpl) InitLocalPeer(initLocal func(peer *net.Peer)) {
-	// Set Magic number of the P2P network
-	p2p.Magic = client.magic
 	// Create peer manager of the P2P network
 	local := new(net.Peer)
 	initLocal(local)
-	client.pm = net.InitPeerManager(local, client.seeds)
+	client.pm = net.NewPeerManager(local, &net.Config{Magic: client.magic, SeedList: client.seeds})
 }
 
 func (client *P2PClientImpl) SetMessageHandler(msgHandler net.MessageHandler) {
@@ -29,6 +25,10 @@ func (client *P2PClientImpl) Start() {
 	client.pm.Start()
 }
 
+func (client *P2PClientImpl) Stop() {
+	client.pm.Stop()
+}
+
 func (client *P2PClientImpl) PeerManager() *net.PeerManager {
 	return client.pm
 }
@@ -109,7 +109,7 @@ func (service *SPVServiceImpl) Start() error {
 	}
 
 	var err error
-	service.SPVWallet, err = spvwallet.Init(service.clientId, service.seeds)
+	service.SPVWallet, err = spvwallet.Init(service.clientId, service.seeds, nil, nil)
 	if err != nil {
 		return err
 	}
@@ -3,8 +3,10 @@ package main
 import (
 	"os"
 	"os/signal"
+	"syscall"
 	"encoding/binary"
 
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/config"
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
@@ -22,12 +24,22 @@ func main() {
 
 	// Initiate SPV service
 	iv, _ := file.GetIV()
-	wallet, err := spvwallet.Init(binary.LittleEndian.Uint64(iv), config.Values().SeedList)
+	values := config.Values()
+	wallet, err := spvwallet.Init(binary.LittleEndian.Uint64(iv), values.SeedList, values.DNSSeeds, values.ConnectPeers)
 	if err != nil {
 		log.Error("Initiate SPV service failed,", err)
 		os.Exit(0)
 	}
 
+	// Set checkpoints by config, there are no built in checkpoints
+	if len(values.Checkpoints) > 0 {
+		checkpoints := make([]sdk.Checkpoint, 0, len(values.Checkpoints))
+		for _, checkpoint := range values.Checkpoints {
+			checkpoints = append(checkpoints, sdk.Checkpoint{Height: checkpoint.Height, Hash: checkpoint.Hash})
+		}
+		wallet.SetCheckpoints(checkpoints)
+	}
+
 	// Handle interrupt signal
 	stop := make(chan int, 1)
 	c := make(chan os.Signal, 1)
@@ -40,6 +52,21 @@ func main() {
 		}
 	}()
 
+	// Reload connect peers on hangup signal
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			values, err := config.Reload()
+			if err != nil {
+				log.Error("Reload config file failed,", err)
+				continue
+			}
+			// An empty list leaves connect only mode
+			wallet.SetConnectPeers(values.ConnectPeers)
+		}
+	}()
+
 	wallet.Start()
 
 	<-stop
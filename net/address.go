@@ -0,0 +1,174 @@
+package net
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+// IPPreference decides which IP versions are used to connect peers
+type IPPreference uint8
+
+const (
+	// Connect peers with both IPv4 and IPv6 addresses
+	IPv4AndIPv6 IPPreference = iota
+	// Connect peers with IPv4 addresses only
+	IPv4Only
+	// Connect peers with IPv6 addresses only
+	IPv6Only
+)
+
+func (p IPPreference) String() string {
+	switch p {
+	case IPv4Only:
+		return "ipv4"
+	case IPv6Only:
+		return "ipv6"
+	default:
+		return "ipv4+ipv6"
+	}
+}
+
+// Return the TCP network name to dial or listen with this preference
+func (p IPPreference) network() string {
+	switch p {
+	case IPv4Only:
+		return "tcp4"
+	case IPv6Only:
+		return "tcp6"
+	default:
+		return "tcp"
+	}
+}
+
+// Return if the address in host:port format can be connected with this preference,
+// host names are always allowed for they are resolved when dialing
+func (p IPPreference) allows(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+
+	switch p {
+	case IPv4Only:
+		return ip.To4() != nil
+	case IPv6Only:
+		return ip.To4() == nil
+	default:
+		return true
+	}
+}
+
+// Format the IP and port into host:port format, IPv6 addresses are
+// enclosed in square brackets and IPv4-mapped addresses are in IPv4 form
+func joinAddr(ip16 [16]byte, port uint16) string {
+	return net.JoinHostPort(net.IP(ip16[:]).String(), strconv.Itoa(int(port)))
+}
+
+// Format the peer to peer network address into host:port format
+func addrString(addr *p2p.Addr) string {
+	return joinAddr(addr.IP, addr.Port)
+}
+
+// Split the address in host:port format into IPv6 form IP and port,
+// return false if the host is not an IP address
+func splitAddr(addr string) ([16]byte, uint16, bool) {
+	var ip16 [16]byte
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ip16, 0, false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return ip16, 0, false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ip16, 0, false
+	}
+	copy(ip16[:], ip.To16())
+	return ip16, uint16(port), true
+}
+
+// Return the canonical form of the address, so the same address
+// written in different ways will be the same entry in the address book
+func normalizeAddr(addr string) string {
+	ip16, port, ok := splitAddr(addr)
+	if !ok {
+		return addr
+	}
+	return joinAddr(ip16, port)
+}
+
+// Return the network group of the address in host:port format, which is the
+// /16 network of an IPv4 address or the /32 network of an IPv6 address.
+// Addresses in the same group are likely run by the same operator, host
+// names are groups of their own for they are not resolved yet.
+func netGroup(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String() + "/32"
+}
+
+var nonRoutableNets = parseCIDRs(
+	"0.0.0.0/8",       // This network
+	"10.0.0.0/8",      // Private network
+	"100.64.0.0/10",   // Shared address space
+	"127.0.0.0/8",     // Loopback
+	"169.254.0.0/16",  // Link local
+	"172.16.0.0/12",   // Private network
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation
+	"192.168.0.0/16",  // Private network
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation
+	"203.0.113.0/24",  // Documentation
+	"224.0.0.0/3",     // Multicast and reserved
+	"::/128",          // Unspecified
+	"::1/128",         // Loopback
+	"100::/64",        // Discard only
+	"2001:db8::/32",   // Documentation
+	"fc00::/7",        // Unique local
+	"fe80::/10",       // Link local
+	"ff00::/8",        // Multicast
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// Return if the IP address can be reached through the public internet,
+// private, loopback, link local and reserved addresses are not routable
+func isRoutable(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, ipNet := range nonRoutableNets {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package net
+
+import (
+	"net"
+	"testing"
+)
+
+func ip16Of(ip string) [16]byte {
+	var ip16 [16]byte
+	copy(ip16[:], net.ParseIP(ip).To16())
+	return ip16
+}
+
+func TestSplitAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		ip   string
+		port uint16
+		ok   bool
+	}{
+		{"127.0.0.1:20866", "127.0.0.1", 20866, true},
+		{"[::1]:20866", "::1", 20866, true},
+		{"[2001:db8::1]:0", "2001:db8::1", 0, true},
+		{"[::ffff:10.0.0.1]:20866", "10.0.0.1", 20866, true},
+		// Missing or invalid ports
+		{"127.0.0.1", "", 0, false},
+		{"127.0.0.1:", "", 0, false},
+		{"127.0.0.1:65536", "", 0, false},
+		{"::1", "", 0, false},
+		{"2001:db8::1:20866", "", 0, false},
+		// Host names are not IP addresses
+		{"localhost:20866", "", 0, false},
+		{"node.elastos.org:20866", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, test := range tests {
+		ip16, port, ok := splitAddr(test.addr)
+		if ok != test.ok {
+			t.Errorf("split %q ok %v, expect %v", test.addr, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if ip16 != ip16Of(test.ip) || port != test.port {
+			t.Errorf("split %q into %v %d, expect %s %d", test.addr, net.IP(ip16[:]), port, test.ip, test.port)
+		}
+	}
+}
+
+func TestJoinAddr(t *testing.T) {
+	tests := []struct {
+		ip   string
+		port uint16
+		addr string
+	}{
+		{"127.0.0.1", 20866, "127.0.0.1:20866"},
+		{"::ffff:127.0.0.1", 20866, "127.0.0.1:20866"},
+		{"::1", 20866, "[::1]:20866"},
+		{"2001:db8::1", 0, "[2001:db8::1]:0"},
+	}
+	for _, test := range tests {
+		if addr := joinAddr(ip16Of(test.ip), test.port); addr != test.addr {
+			t.Errorf("join %s %d into %s, expect %s", test.ip, test.port, addr, test.addr)
+		}
+	}
+}
+
+func TestNormalizeAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		expect string
+	}{
+		{"127.0.0.1:20866", "127.0.0.1:20866"},
+		{"[::ffff:127.0.0.1]:20866", "127.0.0.1:20866"},
+		{"[2001:DB8:0:0::1]:20866", "[2001:db8::1]:20866"},
+		{"[::1]:020866", "[::1]:20866"},
+		// Addresses not in ip:port format are kept as they are
+		{"localhost:20866", "localhost:20866"},
+		{"Node.Elastos.org:20866", "Node.Elastos.org:20866"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"[::1]", "[::1]"},
+	}
+	for _, test := range tests {
+		if addr := normalizeAddr(test.addr); addr != test.expect {
+			t.Errorf("normalize %q into %q, expect %q", test.addr, addr, test.expect)
+		}
+	}
+}
+
+func TestIsRoutable(t *testing.T) {
+	tests := []struct {
+		ip       string
+		routable bool
+	}{
+		{"8.8.8.8", true},
+		{"::ffff:8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"127.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+		{"169.254.0.1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"2001:db8::1", false},
+	}
+	for _, test := range tests {
+		if routable := isRoutable(net.ParseIP(test.ip)); routable != test.routable {
+			t.Errorf("%s routable %v, expect %v", test.ip, routable, test.routable)
+		}
+	}
+}
@@ -1,133 +1,375 @@
 package net
 
 import (
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
 )
 
 const (
+	// The file to persist the address book, prefixed by the network magic number
+	AddrsFile = "peers.json"
+	// The legacy plain addresses list, it will be migrated into the address book
 	CachedAddrsFile = "addrs.cache"
+	// How often the changes of the address book are written to the file
+	AddrsFlushInterval = time.Minute * 10
+
+	// Version of the address book file format
+	AddrsFileVersion = 1
+
+	// An address that never connected will be removed after this many failures
+	MaxAddrFailures = 10
+	// An address not seen or connected for this long will be removed
+	AddrExpireDuration = time.Hour * 24 * 30
+	// An address connected before but keep failing for this long will be removed
+	AddrFailedDuration = time.Hour * 24 * 7
+	// An address attempted in this duration is less likely to be selected
+	RecentAttemptDuration = time.Minute * 10
+
+	// A gossiped address timestamp should not be later than now plus this duration
+	MaxAddrTimeDrift = time.Minute * 10
+	// The timestamp of a gossiped address which is too new or unknown will be set to this long ago
+	UnknownAddrAge = time.Hour * 24 * 5
+	// An address failed all the connection retries will not be selected for this long
+	AddrDemoteDuration = time.Hour * 6
 )
 
+// KnownAddr is an entry of the address book
+type KnownAddr struct {
+	// The address in host:port format, IPv6 addresses are enclosed in square brackets
+	Addr string
+	// The peer which told us about this address, empty for seeds and cached addresses
+	Source string
+	// The services of the peer on this address, if known
+	Services uint64
+	// Last time we heard about this address
+	LastSeen time.Time
+	// Last time we tried to connect this address
+	LastAttempt time.Time
+	// Last time we connected this address successfully
+	LastSuccess time.Time
+	// Failed connection attempts since last success
+	Failures int
+	// The address will not be selected to connect until this time
+	DemotedUntil time.Time
+}
+
+// The on disk format of the address book
+type addrsFile struct {
+	Version int
+	Addrs   []*KnownAddr
+}
+
 type AddrManager struct {
 	sync.RWMutex
+	file      string
 	seeds     []string
-	cached    []string
+	addrs     map[string]*KnownAddr
 	connected map[string]byte
+	banList   *BanList
+	prefer    IPPreference
+	// Max outbound addresses in the same network group, no limit if not positive
+	maxPerGroup int
+	// The address book is changed since it was saved
+	dirty bool
 }
 
-func newAddrManager(seeds []string) *AddrManager {
+func newAddrManager(file string, seeds []string, banList *BanList, prefer IPPreference, maxPerGroup int) *AddrManager {
 	am := &AddrManager{
-		seeds:     make([]string, 0),
-		cached:    make([]string, 0),
-		connected: make(map[string]byte),
+		file:        file,
+		seeds:       make([]string, 0),
+		addrs:       make(map[string]*KnownAddr),
+		connected:   make(map[string]byte),
+		banList:     banList,
+		prefer:      prefer,
+		maxPerGroup: maxPerGroup,
 	}
 
 	// Read seed list from config file
 	for _, addr := range seeds {
-		am.seeds = append(am.seeds, addr)
+		am.seeds = append(am.seeds, normalizeAddr(addr))
 	}
 
-	// Read cached addresses from file
-	data, err := ioutil.ReadFile(CachedAddrsFile)
-	if err != nil {
-		return am
-	}
-	addrs := strings.Split(strings.TrimSpace(string(data)), "\n")
-
-	for _, addr := range addrs {
-		if len(strings.TrimSpace(addr)) != 0 {
-			am.cached = append(am.cached, addr)
-		}
-	}
+	// Read address book from file
+	am.load()
 
 	return am
 }
 
-func (am *AddrManager) GetIdleAddrs(count int) []string {
-	addrMap := make(map[string]string)
+// Return addresses to connect, addresses with better history are more likely to be selected.
+// outbound are the addresses of the outbound peers connected or connecting, together with
+// them no more than maxPerGroup addresses in the same network group will be returned.
+func (am *AddrManager) GetIdleAddrs(count int, outbound []string) []string {
+	am.RLock()
+	defer am.RUnlock()
+
+	now := time.Now()
+	candidates := make(map[string]float64)
 
 	for _, seed := range am.seeds {
-		if am.isConnected(seed) {
+		if am.isConnected(seed) || am.banList.IsBanned(seed) || !am.prefer.allows(seed) {
 			continue
 		}
-		addrMap[seed] = seed
+		candidates[seed] = 1.0
+		if ka, ok := am.addrs[seed]; ok {
+			if ka.isDemoted(now) {
+				delete(candidates, seed)
+				continue
+			}
+			candidates[seed] = ka.chance(now)
+		}
 	}
 
-	for _, cache := range am.cached {
-		if am.isConnected(cache) {
+	for addr, ka := range am.addrs {
+		if _, ok := candidates[addr]; ok {
+			continue
+		}
+		if am.isConnected(addr) || ka.isBad(now) || ka.isDemoted(now) ||
+			am.banList.IsBanned(addr) || !am.prefer.allows(addr) {
 			continue
 		}
-		addrMap[cache] = cache
+		candidates[addr] = ka.chance(now)
 	}
 
-	totalAddrs := len(addrMap)
-	if count > totalAddrs {
-		count = totalAddrs
+	// Remove the candidates in the network groups already full
+	groups := make(map[string]int)
+	for _, addr := range outbound {
+		groups[netGroup(normalizeAddr(addr))]++
+	}
+	for addr := range candidates {
+		if am.groupFull(groups, netGroup(addr)) {
+			delete(candidates, addr)
+		}
 	}
 
-	randAddrs := make([]string, count)
-	for addr := range addrMap {
-		count--
-		randAddrs[count] = addr
-		if count == 0 {
-			break
+	// Weighted random selection without replacement
+	randAddrs := make([]string, 0, count)
+	for len(randAddrs) < count && len(candidates) > 0 {
+		var total float64
+		for _, chance := range candidates {
+			total += chance
+		}
+
+		var selected string
+		r := rand.Float64() * total
+		for addr, chance := range candidates {
+			selected = addr
+			r -= chance
+			if r <= 0 {
+				break
+			}
+		}
+
+		randAddrs = append(randAddrs, selected)
+		delete(candidates, selected)
+
+		// Remove the other candidates in the group if it becomes full
+		group := netGroup(selected)
+		groups[group]++
+		if am.groupFull(groups, group) {
+			for addr := range candidates {
+				if netGroup(addr) == group {
+					delete(candidates, addr)
+				}
+			}
 		}
 	}
 
 	return randAddrs
 }
 
+// Return if the network group has reached the max outbound addresses
+func (am *AddrManager) groupFull(groups map[string]int, group string) bool {
+	return am.maxPerGroup > 0 && groups[group] >= am.maxPerGroup
+}
+
+// Mark the address as connected, this will create the address entry if not exist.
 func (am *AddrManager) AddAddr(addr string) {
+	addr = normalizeAddr(addr)
+
 	am.Lock()
 	defer am.Unlock()
 
 	am.connected[addr] = 'c'
 
-	if !am.isSeed(addr) && !am.isCached(addr) {
-		am.cached = append(am.cached, addr)
-		am.saveCached()
+	now := time.Now()
+	ka := am.getOrCreate(addr)
+	ka.LastSeen = now
+	ka.LastAttempt = now
+	ka.LastSuccess = now
+	ka.Failures = 0
+	ka.DemotedUntil = time.Time{}
+
+	am.dirty = true
+}
+
+// Add an address learned from the given source peer with the time it was last seen,
+// return true if the address is new to the address book.
+func (am *AddrManager) NewAddr(addr, source string, services uint64, seen time.Time) bool {
+	addr = normalizeAddr(addr)
+
+	now := time.Now()
+	if seen.IsZero() || seen.After(now.Add(MaxAddrTimeDrift)) {
+		seen = now.Add(-UnknownAddrAge)
+	}
+
+	am.Lock()
+	defer am.Unlock()
+
+	if ka, ok := am.addrs[addr]; ok {
+		if seen.After(ka.LastSeen) {
+			ka.LastSeen = seen
+		}
+		if services != 0 {
+			ka.Services = services
+		}
+		am.dirty = true
+		return false
 	}
+
+	ka := am.getOrCreate(addr)
+	ka.Source = source
+	ka.Services = services
+	ka.LastSeen = seen
+
+	am.dirty = true
+	return true
+}
+
+// Add addresses learned from the given source, like a DNS seed.
+// Return the addresses new to the address book.
+func (am *AddrManager) NewAddrs(addrs []string, source string) []string {
+	am.Lock()
+	defer am.Unlock()
+
+	now := time.Now()
+	var newAddrs []string
+	for _, addr := range addrs {
+		addr = normalizeAddr(addr)
+		if ka, ok := am.addrs[addr]; ok {
+			ka.LastSeen = now
+			continue
+		}
+
+		ka := am.getOrCreate(addr)
+		ka.Source = source
+		ka.LastSeen = now
+		newAddrs = append(newAddrs, addr)
+	}
+
+	am.dirty = true
+	return newAddrs
+}
+
+// Record a failed connection attempt of the address.
+func (am *AddrManager) FailedAddr(addr string) {
+	addr = normalizeAddr(addr)
+
+	am.Lock()
+	defer am.Unlock()
+
+	ka := am.getOrCreate(addr)
+	ka.LastAttempt = time.Now()
+	ka.Failures++
+
+	am.dirty = true
 }
 
 func (am *AddrManager) DisconnectedAddr(addr string) {
+	addr = normalizeAddr(addr)
+
 	am.Lock()
 	defer am.Unlock()
 
 	delete(am.connected, addr)
 }
 
+// Stop selecting the address to connect for AddrDemoteDuration, the address
+// is kept in the address book so it can be tried again later.
+func (am *AddrManager) DemoteAddr(addr string) {
+	addr = normalizeAddr(addr)
+
+	am.Lock()
+	defer am.Unlock()
+
+	log.Info("AddrManager demote addr:", addr)
+	ka := am.getOrCreate(addr)
+	ka.DemotedUntil = time.Now().Add(AddrDemoteDuration)
+
+	am.dirty = true
+}
+
 func (am *AddrManager) DiscardAddr(addr string) {
+	addr = normalizeAddr(addr)
+
 	am.Lock()
 	defer am.Unlock()
 
 	log.Info("AddrManager discard addr:", addr)
-	for i, cache := range am.cached {
-		if cache == addr {
-			am.cached = append(am.cached[:i], am.cached[i+1:]...)
-			am.saveCached()
-			return
-		}
+	if _, ok := am.addrs[addr]; ok {
+		delete(am.addrs, addr)
+		am.dirty = true
 	}
 }
 
-func (am *AddrManager) isSeed(addr string) bool {
-	for _, seed := range am.seeds {
-		if seed == addr {
-			return true
+// Return at most count random addresses to share with other peers, only
+// routable IP addresses which are not going to be removed are included.
+func (am *AddrManager) SampleAddrs(count int) []KnownAddr {
+	am.RLock()
+	defer am.RUnlock()
+
+	now := time.Now()
+	addrs := make([]KnownAddr, 0, len(am.addrs))
+	for _, ka := range am.addrs {
+		if ka.isBad(now) || ka.isDemoted(now) || !ka.isRoutable() || am.banList.IsBanned(ka.Addr) {
+			continue
 		}
+		addrs = append(addrs, *ka)
 	}
-	return false
+
+	// Shuffle and take the first count addresses
+	for i := range addrs {
+		j := rand.Intn(i + 1)
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	}
+	if len(addrs) > count {
+		addrs = addrs[:count]
+	}
+	return addrs
 }
 
-func (am *AddrManager) isCached(addr string) bool {
-	for _, cached := range am.cached {
-		if cached == addr {
+// Return the address book entries
+func (am *AddrManager) KnownAddrs() []KnownAddr {
+	am.RLock()
+	defer am.RUnlock()
+
+	addrs := make([]KnownAddr, 0, len(am.addrs))
+	for _, ka := range am.addrs {
+		addrs = append(addrs, *ka)
+	}
+	return addrs
+}
+
+func (am *AddrManager) getOrCreate(addr string) *KnownAddr {
+	ka, ok := am.addrs[addr]
+	if !ok {
+		ka = &KnownAddr{Addr: addr, LastSeen: time.Now()}
+		am.addrs[addr] = ka
+	}
+	return ka
+}
+
+func (am *AddrManager) isSeed(addr string) bool {
+	for _, seed := range am.seeds {
+		if seed == addr {
 			return true
 		}
 	}
@@ -139,22 +381,182 @@ func (am *AddrManager) isConnected(addr string) bool {
 	return ok
 }
 
-func (am *AddrManager) saveCached() {
-	var cached string
-	for _, addr := range am.cached {
-		cached += string(addr)
-		cached += "\n"
+// Remove stale addresses, seeds will never be removed
+func (am *AddrManager) expireAddrs() {
+	now := time.Now()
+	for addr, ka := range am.addrs {
+		if am.isSeed(addr) || am.isConnected(addr) {
+			continue
+		}
+		if ka.isBad(now) {
+			log.Debug("AddrManager expire addr:", addr)
+			delete(am.addrs, addr)
+		}
 	}
+}
 
-	file, err := os.OpenFile(CachedAddrsFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+func (am *AddrManager) load() {
+	data, err := ioutil.ReadFile(am.file)
 	if err != nil {
-		fmt.Println("Open cached addresses failed")
+		am.loadCached()
 		return
 	}
 
-	_, err = file.Write([]byte(cached))
+	var file addrsFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Error("AddrManager decode addresses file failed, ", err)
+		return
+	}
+
+	if file.Version != AddrsFileVersion {
+		log.Error("AddrManager unknown addresses file version ", file.Version)
+		return
+	}
+
+	for _, ka := range file.Addrs {
+		if len(strings.TrimSpace(ka.Addr)) == 0 {
+			continue
+		}
+		ka.Addr = normalizeAddr(ka.Addr)
+		am.addrs[ka.Addr] = ka
+	}
+	am.expireAddrs()
+}
+
+// Migrate addresses from the legacy addrs.cache file
+func (am *AddrManager) loadCached() {
+	data, err := ioutil.ReadFile(CachedAddrsFile)
+	if err != nil {
+		return
+	}
+
+	addrs := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, addr := range addrs {
+		addr = strings.TrimSpace(addr)
+		if len(addr) != 0 {
+			am.getOrCreate(normalizeAddr(addr))
+		}
+	}
+	am.save()
+}
+
+// Save the address book if it is changed, changes are not written on every
+// call, the peer manager flushes them every AddrsFlushInterval and when stopped.
+func (am *AddrManager) flush() {
+	am.Lock()
+	defer am.Unlock()
+
+	if am.dirty {
+		am.save()
+	}
+}
+
+func (am *AddrManager) save() {
+	am.dirty = false
+
+	am.expireAddrs()
+
+	file := addrsFile{
+		Version: AddrsFileVersion,
+		Addrs:   make([]*KnownAddr, 0, len(am.addrs)),
+	}
+	for _, ka := range am.addrs {
+		file.Addrs = append(file.Addrs, ka)
+	}
+
+	data, err := json.MarshalIndent(file, "", "\t")
 	if err != nil {
-		fmt.Println("Write cached addresses failed")
+		log.Error("AddrManager encode addresses failed, ", err)
 		return
 	}
+
+	err = writeFileAtomic(am.file, data)
+	if err != nil {
+		log.Error("AddrManager write addresses file failed, ", err)
+	}
+}
+
+// Return if the address should be removed from the address book
+func (ka *KnownAddr) isBad(now time.Time) bool {
+	// Do not remove an address just attempted
+	if now.Sub(ka.LastAttempt) < time.Minute {
+		return false
+	}
+
+	// Not seen or connected for a long time
+	if now.Sub(ka.LastSeen) > AddrExpireDuration && now.Sub(ka.LastSuccess) > AddrExpireDuration {
+		return true
+	}
+
+	// Never connected and keep failing
+	if ka.LastSuccess.IsZero() && ka.Failures >= MaxAddrFailures {
+		return true
+	}
+
+	// Keep failing for a long time
+	if ka.Failures >= MaxAddrFailures && now.Sub(ka.LastSuccess) > AddrFailedDuration {
+		return true
+	}
+
+	return false
+}
+
+// Return if the address has been demoted and should not be selected to connect
+func (ka *KnownAddr) isDemoted(now time.Time) bool {
+	return now.Before(ka.DemotedUntil)
+}
+
+// Return if the address is a routable IP address
+func (ka *KnownAddr) isRoutable() bool {
+	ip16, _, ok := splitAddr(ka.Addr)
+	return ok && isRoutable(ip16[:])
+}
+
+// Return the relative chance this address should be selected
+func (ka *KnownAddr) chance(now time.Time) float64 {
+	chance := 1.0
+
+	// Deprioritize very recent attempts
+	if now.Sub(ka.LastAttempt) < RecentAttemptDuration {
+		chance *= 0.01
+	}
+
+	// Deprioritize addresses keep failing
+	failures := ka.Failures
+	if failures > 8 {
+		failures = 8
+	}
+	chance *= math.Pow(0.66, float64(failures))
+
+	return chance
+}
+
+// Write data to a temp file and rename it to the target file,
+// so the target file is either the old version or the new version.
+// Every write has it's own temp file, so concurrent writes do not mix.
+func writeFileAtomic(filename string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpFile := file.Name()
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFile)
+		return err
+	}
+
+	err = os.Rename(tmpFile, filename)
+	if err != nil {
+		os.Remove(tmpFile)
+	}
+	return err
 }
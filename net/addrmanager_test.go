@@ -0,0 +1,270 @@
+package net
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+func TestMain(m *testing.M) {
+	log.Init()
+	os.Exit(m.Run())
+}
+
+func newTestAddrManager(t *testing.T, maxPerGroup int) (*AddrManager, func()) {
+	dir, err := ioutil.TempDir("", "addrmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	banList := newBanList(filepath.Join(dir, BannedFile))
+	am := newAddrManager(filepath.Join(dir, AddrsFile), nil, banList, IPv4AndIPv6, maxPerGroup)
+	return am, func() { os.RemoveAll(dir) }
+}
+
+func countGroups(addrs []string) map[string]int {
+	groups := make(map[string]int)
+	for _, addr := range addrs {
+		groups[netGroup(addr)]++
+	}
+	return groups
+}
+
+func TestNetGroup(t *testing.T) {
+	tests := []struct {
+		addr  string
+		group string
+	}{
+		{"1.2.3.4:20866", "1.2.0.0/16"},
+		{"1.2.255.255:20866", "1.2.0.0/16"},
+		{"1.3.3.4:20866", "1.3.0.0/16"},
+		{"[::ffff:1.2.3.4]:20866", "1.2.0.0/16"},
+		{"[2001:db8:1::1]:20866", "2001:db8::/32"},
+		{"[2001:db8:ffff::1]:20866", "2001:db8::/32"},
+		{"[2001:db9::1]:20866", "2001:db9::/32"},
+		{"node.elastos.org:20866", "node.elastos.org"},
+	}
+
+	for _, test := range tests {
+		if group := netGroup(test.addr); group != test.group {
+			t.Errorf("netGroup(%s) = %s, expect %s", test.addr, group, test.group)
+		}
+	}
+}
+
+func TestGetIdleAddrsLimitsNetGroup(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, 2)
+	defer cleanup()
+
+	// Many addresses in one /16 and a few in other /16s
+	var addrs []string
+	for i := 1; i <= 20; i++ {
+		addrs = append(addrs, fmt.Sprintf("1.2.%d.1:20866", i))
+	}
+	for i := 3; i <= 6; i++ {
+		addrs = append(addrs, fmt.Sprintf("1.%d.0.1:20866", i))
+	}
+	am.NewAddrs(addrs, "test")
+
+	for i := 0; i < 10; i++ {
+		selected := am.GetIdleAddrs(10, nil)
+		if len(selected) != 6 {
+			t.Fatalf("selected %d addresses, expect 6", len(selected))
+		}
+		for group, count := range countGroups(selected) {
+			if count > 2 {
+				t.Fatalf("selected %d addresses in group %s, expect at most 2", count, group)
+			}
+		}
+	}
+}
+
+func TestGetIdleAddrsCountsOutbound(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, 2)
+	defer cleanup()
+
+	am.NewAddrs([]string{
+		"1.2.0.1:20866", "1.2.0.2:20866", "1.2.0.3:20866",
+		"1.3.0.1:20866", "1.3.0.2:20866",
+		"1.4.0.1:20866",
+	}, "test")
+
+	// Group 1.2.0.0/16 is full, group 1.3.0.0/16 takes one more
+	outbound := []string{"1.2.9.9:20866", "1.2.8.8:20866", "1.3.9.9:20866"}
+	selected := am.GetIdleAddrs(10, outbound)
+	groups := countGroups(selected)
+	if len(selected) != 2 || groups["1.3.0.0/16"] != 1 || groups["1.4.0.0/16"] != 1 {
+		t.Errorf("selected %v, expect one address in 1.3.0.0/16 and one in 1.4.0.0/16", selected)
+	}
+}
+
+func TestGetIdleAddrsLimitsIPv6NetGroup(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, 1)
+	defer cleanup()
+
+	am.NewAddrs([]string{
+		"[2400:1::1]:20866", "[2400:1:ffff::1]:20866",
+		"[2400:2::1]:20866",
+		"1.2.0.1:20866", "1.2.0.2:20866",
+	}, "test")
+
+	selected := am.GetIdleAddrs(10, nil)
+	if len(selected) != 3 {
+		t.Errorf("selected %v, expect one address in each of the 3 groups", selected)
+	}
+	for group, count := range countGroups(selected) {
+		if count > 1 {
+			t.Errorf("selected %d addresses in group %s, expect at most 1", count, group)
+		}
+	}
+}
+
+func TestGetIdleAddrsWithoutNetGroupLimit(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, -1)
+	defer cleanup()
+
+	am.NewAddrs([]string{"1.2.0.1:20866", "1.2.0.2:20866", "1.2.0.3:20866"}, "test")
+
+	selected := am.GetIdleAddrs(10, []string{"1.2.9.9:20866"})
+	if len(selected) != 3 {
+		t.Errorf("selected %v, expect all the 3 addresses", selected)
+	}
+}
+
+func TestGetIdleAddrsSkipsConnected(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, 2)
+	defer cleanup()
+
+	am.NewAddrs([]string{"1.2.0.1:20866", "1.3.0.1:20866"}, "test")
+	am.AddAddr("1.2.0.1:20866")
+
+	selected := am.GetIdleAddrs(10, []string{"1.2.0.1:20866"})
+	if len(selected) != 1 || selected[0] != "1.3.0.1:20866" {
+		t.Errorf("selected %v, expect only 1.3.0.1:20866", selected)
+	}
+}
+
+func TestDemoteAddr(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, -1)
+	defer cleanup()
+
+	am.NewAddrs([]string{"1.2.0.1:20866", "1.3.0.1:20866"}, "test")
+	am.DemoteAddr("1.2.0.1:20866")
+
+	// The demoted address is kept but not selected
+	if len(am.KnownAddrs()) != 2 {
+		t.Fatalf("demoted address removed from the address book")
+	}
+	selected := am.GetIdleAddrs(10, nil)
+	if len(selected) != 1 || selected[0] != "1.3.0.1:20866" {
+		t.Errorf("selected %v, expect only 1.3.0.1:20866", selected)
+	}
+
+	// A successful connection clears the demotion
+	am.AddAddr("1.2.0.1:20866")
+	am.DisconnectedAddr("1.2.0.1:20866")
+	if selected := am.GetIdleAddrs(10, nil); len(selected) != 2 {
+		t.Errorf("selected %v, expect both addresses", selected)
+	}
+}
+
+func TestPeerManagersDataDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "peermanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Peer managers of the same network keep their own files
+	dir1, dir2 := filepath.Join(dir, "1"), filepath.Join(dir, "2")
+	pm1 := NewPeerManager(new(Peer), &Config{Magic: 1, DataDir: dir1, DisableListen: true})
+	pm2 := NewPeerManager(new(Peer), &Config{Magic: 1, DataDir: dir2, DisableListen: true})
+	pm1.addrManager.NewAddrs([]string{"1.2.0.1:20866"}, "test")
+	pm2.addrManager.NewAddrs([]string{"1.3.0.1:20866"}, "test")
+	pm1.Stop()
+	pm2.Stop()
+
+	for _, test := range []struct {
+		dir  string
+		addr string
+	}{
+		{dir1, "1.2.0.1:20866"},
+		{dir2, "1.3.0.1:20866"},
+	} {
+		am := newAddrManager(filepath.Join(test.dir, "1_"+AddrsFile), nil, nil, IPv4AndIPv6, -1)
+		addrs := am.KnownAddrs()
+		if len(addrs) != 1 || addrs[0].Addr != test.addr {
+			t.Errorf("address book in %s has %v, expect only %s", test.dir, addrs, test.addr)
+		}
+	}
+}
+
+func TestAddrManagerFlush(t *testing.T) {
+	am, cleanup := newTestAddrManager(t, -1)
+	defer cleanup()
+
+	load := func() []KnownAddr {
+		return newAddrManager(am.file, nil, nil, IPv4AndIPv6, -1).KnownAddrs()
+	}
+
+	// Changes are kept in memory until flushed
+	am.AddAddr("1.2.0.1:20866")
+	am.NewAddr("1.3.0.1:20866", "1.2.0.1:20866", 0, time.Now())
+	if addrs := load(); len(addrs) != 0 {
+		t.Errorf("%d addresses written before flushed", len(addrs))
+	}
+	am.flush()
+	if addrs := load(); len(addrs) != 2 {
+		t.Errorf("%d addresses written after flushed, expect 2", len(addrs))
+	}
+
+	// Nothing is written if not changed
+	os.Remove(am.file)
+	am.flush()
+	if _, err := os.Stat(am.file); !os.IsNotExist(err) {
+		t.Errorf("address book written without changes, %v", err)
+	}
+}
+
+func TestWriteFileAtomicConcurrently(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, AddrsFile)
+	contents := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		data := strings.Repeat(fmt.Sprint(i), 10000)
+		contents[data] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := writeFileAtomic(filename, []byte(data)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The file is one of the writes as a whole, and no temp files are left
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contents[string(data)] {
+		t.Error("file content is mixed by concurrent writes")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("%d files in the directory, expect only %s", len(files), AddrsFile)
+	}
+}
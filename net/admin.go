@@ -0,0 +1,76 @@
+package net
+
+import (
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+// Add the address into the address book and connect it now
+func (pm *PeerManager) AddNode(addr string) {
+	addr = normalizeAddr(addr)
+	log.Info("PeerManager add node ", addr)
+	pm.addrManager.NewAddrs([]string{addr}, "manual")
+	pm.ConnectPeer(addr)
+}
+
+// Disconnect the running peer with the given ID, return false if not found
+func (pm *PeerManager) DisconnectPeerByID(id uint64, reason string) bool {
+	for _, peer := range pm.runningPeerList() {
+		if peer.ID() == id {
+			pm.DisconnectPeerWithReason(peer, reason)
+			return true
+		}
+	}
+	return false
+}
+
+// Disconnect the running peers on the host of the given address, the port is
+// ignored. Return how many peers have been disconnected.
+func (pm *PeerManager) DisconnectHost(addr, reason string) int {
+	host := hostOf(addr)
+	var count int
+	for _, peer := range pm.runningPeerList() {
+		if hostOf(peer.AddrString()) == host {
+			pm.DisconnectPeerWithReason(peer, reason)
+			count++
+		}
+	}
+	return count
+}
+
+// Ban the host of the given address for the duration and disconnect the peers on it
+func (pm *PeerManager) Ban(addr string, duration time.Duration, reason string) {
+	pm.banList.Ban(addr, duration, reason)
+
+	host := hostOf(addr)
+	var banned bool
+	for _, peer := range pm.runningPeerList() {
+		if hostOf(peer.AddrString()) == host {
+			pm.notifyEvent(EventPeerBanned, peer, peer.AddrString(), reason)
+			pm.DisconnectPeerWithReason(peer, "banned for "+reason)
+			banned = true
+		}
+	}
+	if !banned {
+		pm.notifyEvent(EventPeerBanned, nil, addr, reason)
+	}
+}
+
+// Lift the ban of the host of the given address
+func (pm *PeerManager) Unban(addr string) {
+	log.Info("PeerManager unban ", hostOf(addr))
+	pm.banList.Unban(addr)
+}
+
+// Return the peers connected or in handshake
+func (pm *PeerManager) runningPeerList() []*Peer {
+	pm.runningLock.Lock()
+	defer pm.runningLock.Unlock()
+
+	peers := make([]*Peer, 0, len(pm.runningPeers))
+	for peer := range pm.runningPeers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
@@ -0,0 +1,180 @@
+package net
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+const (
+	// The file to persist banned hosts, prefixed by the network magic number
+	BannedFile = "banned.json"
+
+	// Version of the banned hosts file format
+	BannedFileVersion = 1
+
+	// Default duration of a ban
+	DefaultBanDuration = time.Hour * 24
+)
+
+// BannedHost is an entry of the ban list
+type BannedHost struct {
+	// The banned IP address or host name
+	Host string
+	// The ban will be lifted at this time
+	Until time.Time
+	// Why the host was banned
+	Reason string
+}
+
+// The on disk format of the ban list
+type bannedFile struct {
+	Version int
+	Banned  []*BannedHost
+}
+
+// BanList keeps the banned hosts with their expiry, bans are persisted in BannedFile.
+type BanList struct {
+	sync.RWMutex
+	file   string
+	banned map[string]*BannedHost
+}
+
+func newBanList(file string) *BanList {
+	bl := &BanList{file: file, banned: make(map[string]*BannedHost)}
+	bl.load()
+	return bl
+}
+
+// Ban the host of the given address for the duration
+func (bl *BanList) Ban(addr string, duration time.Duration, reason string) {
+	bl.Lock()
+	defer bl.Unlock()
+
+	host := hostOf(addr)
+	log.Warn("BanList ban host:", host, " for ", duration, ", reason: ", reason)
+	bl.banned[host] = &BannedHost{
+		Host:   host,
+		Until:  time.Now().Add(duration),
+		Reason: reason,
+	}
+	bl.save()
+}
+
+// Lift the ban of the host of the given address
+func (bl *BanList) Unban(addr string) {
+	bl.Lock()
+	defer bl.Unlock()
+
+	host := hostOf(addr)
+	if _, ok := bl.banned[host]; ok {
+		delete(bl.banned, host)
+		bl.save()
+	}
+}
+
+// Return if the host of the given address is banned
+func (bl *BanList) IsBanned(addr string) bool {
+	bl.RLock()
+	defer bl.RUnlock()
+
+	ban, ok := bl.banned[hostOf(addr)]
+	if !ok {
+		return false
+	}
+	return time.Now().Before(ban.Until)
+}
+
+// Return all bans not expired
+func (bl *BanList) Banned() []BannedHost {
+	bl.Lock()
+	defer bl.Unlock()
+
+	bl.expireBans()
+	banned := make([]BannedHost, 0, len(bl.banned))
+	for _, ban := range bl.banned {
+		banned = append(banned, *ban)
+	}
+	return banned
+}
+
+// Lift all bans
+func (bl *BanList) Clear() {
+	bl.Lock()
+	defer bl.Unlock()
+
+	bl.banned = make(map[string]*BannedHost)
+	bl.save()
+}
+
+func (bl *BanList) expireBans() {
+	now := time.Now()
+	for host, ban := range bl.banned {
+		if !now.Before(ban.Until) {
+			delete(bl.banned, host)
+		}
+	}
+}
+
+func (bl *BanList) load() {
+	data, err := ioutil.ReadFile(bl.file)
+	if err != nil {
+		return
+	}
+
+	var file bannedFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		log.Error("BanList decode banned file failed, ", err)
+		return
+	}
+
+	if file.Version != BannedFileVersion {
+		log.Error("BanList unknown banned file version ", file.Version)
+		return
+	}
+
+	for _, ban := range file.Banned {
+		bl.banned[ban.Host] = ban
+	}
+	bl.expireBans()
+}
+
+func (bl *BanList) save() {
+	bl.expireBans()
+
+	file := bannedFile{
+		Version: BannedFileVersion,
+		Banned:  make([]*BannedHost, 0, len(bl.banned)),
+	}
+	for _, ban := range bl.banned {
+		file.Banned = append(file.Banned, ban)
+	}
+
+	data, err := json.MarshalIndent(file, "", "\t")
+	if err != nil {
+		log.Error("BanList encode banned hosts failed, ", err)
+		return
+	}
+
+	err = writeFileAtomic(bl.file, data)
+	if err != nil {
+		log.Error("BanList write banned file failed, ", err)
+	}
+}
+
+// Return the host part of an address, or the address itself if it has no port
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
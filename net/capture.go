@@ -0,0 +1,237 @@
+package net
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p/msg"
+)
+
+/*
+A capture file records the messages sent to and received from peers,
+all integers are in little endian.
+
+	File header
+	  [8]byte  "ELASPVCP"
+	  uint32   format version, CaptureVersion
+	  uint32   magic number of the peer to peer network
+	Records, repeated until the end of the file
+	  int64    timestamp in unix nanoseconds
+	  uint8    direction, 0 received from the peer, 1 sent to the peer
+	  uint64   peer id, 0 for messages sent before the version message of the peer is handled
+	  [12]byte command, zero padded
+	  uint32   payload length
+	  []byte   payload, the serialized message without the message header
+
+Received messages are recorded before they are handled, so they come before
+the replies they cause. A received version message is recorded with the peer
+id it carries, which the peer is known by from then on.
+*/
+const (
+	CaptureFileMagic = "ELASPVCP"
+	CaptureVersion   = 1
+
+	// Max payload length of a record
+	MaxCapturePayload = 32 * 1024 * 1024
+)
+
+// The direction of a captured message
+type CaptureDirection uint8
+
+const (
+	CaptureRecv CaptureDirection = iota
+	CaptureSent
+)
+
+func (d CaptureDirection) String() string {
+	if d == CaptureSent {
+		return "sent"
+	}
+	return "received"
+}
+
+// CaptureRecord is a message sent to or received from a peer
+type CaptureRecord struct {
+	Time      time.Time
+	Direction CaptureDirection
+	PeerID    uint64
+	Command   string
+	Payload   []byte
+}
+
+// CaptureWriter writes records into a capture file
+type CaptureWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// Create a capture writer of the network identified by the magic number,
+// the file header will be written immediately.
+func NewCaptureWriter(w io.Writer, magic uint32) (*CaptureWriter, error) {
+	header := make([]byte, len(CaptureFileMagic)+8)
+	copy(header, CaptureFileMagic)
+	binary.LittleEndian.PutUint32(header[len(CaptureFileMagic):], CaptureVersion)
+	binary.LittleEndian.PutUint32(header[len(CaptureFileMagic)+4:], magic)
+	_, err := w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{w: w}, nil
+}
+
+// Write a record, records are written in the order of calls
+func (cw *CaptureWriter) Write(record *CaptureRecord) error {
+	if len(record.Command) > msgCmdLen {
+		return fmt.Errorf("capture command %s too long", record.Command)
+	}
+
+	buf := make([]byte, 8+1+8+msgCmdLen+4, 8+1+8+msgCmdLen+4+len(record.Payload))
+	offset := 0
+	binary.LittleEndian.PutUint64(buf[offset:], uint64(record.Time.UnixNano()))
+	offset += 8
+	buf[offset] = byte(record.Direction)
+	offset += 1
+	binary.LittleEndian.PutUint64(buf[offset:], record.PeerID)
+	offset += 8
+	copy(buf[offset:offset+msgCmdLen], record.Command)
+	offset += msgCmdLen
+	binary.LittleEndian.PutUint32(buf[offset:], uint32(len(record.Payload)))
+	buf = append(buf, record.Payload...)
+
+	cw.Lock()
+	defer cw.Unlock()
+	_, err := cw.w.Write(buf)
+	return err
+}
+
+// Close the underlying writer if it is a closer
+func (cw *CaptureWriter) Close() error {
+	cw.Lock()
+	defer cw.Unlock()
+	if closer, ok := cw.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// CaptureReader reads records from a capture file
+type CaptureReader struct {
+	r     io.Reader
+	magic uint32
+}
+
+// Create a capture reader, the file header will be read and verified immediately.
+func NewCaptureReader(r io.Reader) (*CaptureReader, error) {
+	header := make([]byte, len(CaptureFileMagic)+8)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	if string(header[:len(CaptureFileMagic)]) != CaptureFileMagic {
+		return nil, errors.New("not a capture file")
+	}
+	version := binary.LittleEndian.Uint32(header[len(CaptureFileMagic):])
+	if version != CaptureVersion {
+		return nil, fmt.Errorf("unknown capture file version %d", version)
+	}
+	magic := binary.LittleEndian.Uint32(header[len(CaptureFileMagic)+4:])
+	return &CaptureReader{r: r, magic: magic}, nil
+}
+
+// Return the magic number of the captured network
+func (cr *CaptureReader) Magic() uint32 {
+	return cr.magic
+}
+
+// Read the next record, io.EOF is returned at the end of the file
+func (cr *CaptureReader) Read() (*CaptureRecord, error) {
+	buf := make([]byte, 8+1+8+msgCmdLen+4)
+	_, err := io.ReadFull(cr.r, buf)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read capture record failed, %s", err)
+	}
+
+	record := new(CaptureRecord)
+	offset := 0
+	record.Time = time.Unix(0, int64(binary.LittleEndian.Uint64(buf[offset:])))
+	offset += 8
+	record.Direction = CaptureDirection(buf[offset])
+	offset += 1
+	record.PeerID = binary.LittleEndian.Uint64(buf[offset:])
+	offset += 8
+	record.Command = string(bytes.TrimRight(buf[offset:offset+msgCmdLen], "\x00"))
+	offset += msgCmdLen
+	length := binary.LittleEndian.Uint32(buf[offset:])
+	if length > MaxCapturePayload {
+		return nil, fmt.Errorf("capture record payload too large, %d bytes", length)
+	}
+
+	record.Payload = make([]byte, length)
+	_, err = io.ReadFull(cr.r, record.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("read capture record payload failed, %s", err)
+	}
+	return record, nil
+}
+
+// Open the capture file of the config, capture is disabled if it fails
+func (pm *PeerManager) openCapture(file string) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		log.Error("Open capture file failed, ", err)
+		return
+	}
+	pm.capture, err = NewCaptureWriter(f, pm.magic)
+	if err != nil {
+		log.Error("Write capture file failed, ", err)
+		f.Close()
+	}
+}
+
+// Record a message if capture is enabled
+func (pm *PeerManager) captureMsg(direction CaptureDirection, id uint64, cmd string, payload []byte) {
+	if pm.capture == nil {
+		return
+	}
+	err := pm.capture.Write(&CaptureRecord{
+		Time:      time.Now(),
+		Direction: direction,
+		PeerID:    id,
+		Command:   cmd,
+		Payload:   payload,
+	})
+	if err != nil {
+		log.Error("Write capture record failed, ", err)
+	}
+}
+
+// Record a received message if capture is enabled
+func (pm *PeerManager) captureRecv(peer *Peer, msg p2p.Message) {
+	if pm.capture == nil {
+		return
+	}
+	payload := new(bytes.Buffer)
+	err := msg.Serialize(payload)
+	if err != nil {
+		log.Error("Serialize captured message failed, ", err)
+		return
+	}
+	// The peer id is set by handling the version message, use the one it carries
+	id := peer.ID()
+	if version, ok := msg.(*Version); ok {
+		id = version.Nonce
+	}
+	pm.captureMsg(CaptureRecv, id, msg.CMD(), payload.Bytes())
+}
@@ -0,0 +1,80 @@
+package net
+
+import (
+	"net"
+	"time"
+)
+
+// Config is the configuration of a PeerManager
+type Config struct {
+	// Magic number of the peer to peer network
+	Magic uint32
+
+	// Seed addresses in host:port format
+	SeedList []string
+
+	// DNS seeds in host:port format, the host name resolves to the
+	// addresses of many peers and the port is used to connect them.
+	DNSSeeds []string
+
+	// Resolver resolves DNS seeds, the system resolver is used if it is
+	// not set. DNS seeds are not resolved when a custom Dialer is set
+	// without a Resolver, to keep lookups from bypassing a proxy.
+	Resolver Resolver
+
+	// Which IP versions are used to connect peers, both IPv4 and IPv6 by default
+	IPPreference IPPreference
+
+	// Dialer makes outbound connections, peers are connected
+	// directly with TCP if it is not set
+	Dialer Dialer
+
+	// Listener accepts inbound connections, a TCP listener on the
+	// local peer port is created if it is not set
+	Listener net.Listener
+
+	// Do not accept any inbound connections
+	DisableListen bool
+
+	// Max inbound connections, MaxInboundCount is used if not set
+	MaxInbound int
+
+	// Max outbound connections, MaxOutboundCount is used if not set
+	MaxOutbound int
+
+	// Max connections with the same IP address, MaxPerIPCount is used if not set
+	MaxPerIP int
+
+	// Max outbound connections in the same network group, which is the /16
+	// network of IPv4 or the /32 network of IPv6, to make it harder for an
+	// attacker owning a few networks to take all the outbound connections.
+	// MaxPerNetGroupCount is used if not set, a negative value disables the limit.
+	MaxPerNetGroup int
+
+	// Connect only to these peers in host:port format, setting them enables
+	// connect only mode. Seeds, DNS seeds, the address book and gossiped
+	// addresses are not used to make connections, these peers are reconnected
+	// forever, and inbound connections are refused.
+	// Use PeerManager.SetConnectPeers to reload them at runtime, reloading an
+	// empty list leaves connect only mode.
+	ConnectPeers []string
+
+	// How often a ping is sent to each peer, DefaultPingInterval is used if not set
+	PingInterval time.Duration
+
+	// Disconnect a peer sent nothing, or not answered a ping with a pong,
+	// in this duration. DefaultPingTimeout is used if not set.
+	PingTimeout time.Duration
+
+	// Override the message rate limits by command, see DefaultRateLimits
+	MessageRateLimits map[string]RateLimit
+
+	// The directory of the address book and the ban list files, the working
+	// directory is used if it is not set. Peer managers of the same network
+	// in one process must use different directories, or they overwrite each other's files.
+	DataDir string
+
+	// Record every message sent and received into this file,
+	// see capture.go for the file format and replay.go to replay it
+	CaptureFile string
+}
@@ -0,0 +1,99 @@
+package net
+
+import (
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+/*
+In connect only mode, the peer manager connects only the connect peers, like
+trusted full nodes run by ourselves. Seeds, DNS seeds, the address book and
+addresses gossiped by peers are not used to make connections, connect peers
+are reconnected forever, and inbound connections are refused.
+*/
+
+// Return if the peer manager is in connect only mode
+func (pm *PeerManager) ConnectOnly() bool {
+	pm.connectLock.RLock()
+	defer pm.connectLock.RUnlock()
+
+	return pm.connectOnly
+}
+
+// Return the connect peers in host:port format
+func (pm *PeerManager) ConnectPeers() []string {
+	pm.connectLock.RLock()
+	defer pm.connectLock.RUnlock()
+
+	addrs := make([]string, len(pm.connectAddrs))
+	copy(addrs, pm.connectAddrs)
+	return addrs
+}
+
+// Replace the connect peers, it can be called at runtime to reload the list.
+// A non empty list switches to connect only mode, peers not in the new list are
+// disconnected, and new peers are connected at the next connection check.
+// An empty list leaves connect only mode, connected peers are kept and more
+// peers are connected from the seeds and the address book again.
+func (pm *PeerManager) SetConnectPeers(addrs []string) {
+	connectPeers := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		connectPeers = append(connectPeers, normalizeAddr(addr))
+	}
+
+	pm.connectLock.Lock()
+	pm.connectOnly = len(connectPeers) > 0
+	pm.connectAddrs = connectPeers
+	pm.connectLock.Unlock()
+	if len(connectPeers) == 0 {
+		log.Info("PeerManager leave connect only mode")
+		return
+	}
+	log.Info("PeerManager connect only to ", connectPeers)
+
+	for _, peer := range pm.runningPeerList() {
+		if peer.inbound || !pm.isConnectPeer(peer.AddrString()) {
+			pm.DisconnectPeerWithReason(peer, "not a connect peer")
+		}
+	}
+}
+
+// Return if the address is allowed to be dialed, in connect only mode
+// only the connect peers are allowed
+func (pm *PeerManager) canDial(addr string) bool {
+	pm.connectLock.RLock()
+	defer pm.connectLock.RUnlock()
+
+	return !pm.connectOnly || pm.isConnectPeerLocked(addr)
+}
+
+// Return if the address is one of the connect peers
+func (pm *PeerManager) isConnectPeer(addr string) bool {
+	pm.connectLock.RLock()
+	defer pm.connectLock.RUnlock()
+
+	return pm.isConnectPeerLocked(addr)
+}
+
+func (pm *PeerManager) isConnectPeerLocked(addr string) bool {
+	addr = normalizeAddr(addr)
+	for _, connectPeer := range pm.connectAddrs {
+		if connectPeer == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Connect the connect peers which are not connected or connecting
+func (pm *PeerManager) keepConnectPeers() {
+	connected := make(map[string]bool)
+	for _, peer := range pm.ConnectedPeers() {
+		connected[peer.AddrString()] = true
+	}
+
+	for _, addr := range pm.ConnectPeers() {
+		if !connected[addr] {
+			pm.ConnectPeer(addr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package net
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+func newConnectOnlyManager(t *testing.T, connectPeers ...string) (*PeerManager, func()) {
+	dataDir, err := ioutil.TempDir("", "connectonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewPeerManager(new(Peer), &Config{
+		Dialer:        NewPipeNetwork(),
+		DisableListen: true,
+		ConnectPeers:  connectPeers,
+		DataDir:       dataDir,
+	})
+	return pm, func() { os.RemoveAll(dataDir) }
+}
+
+func TestConnectPeerRetryForever(t *testing.T) {
+	pm, cleanup := newConnectOnlyManager(t, "10.0.0.1:20866")
+	defer cleanup()
+	cm := pm.connManager
+
+	// Both addresses have failed too many times
+	for _, addr := range []string{"10.0.0.1:20866", "10.0.0.2:20866"} {
+		cm.Lock()
+		cm.connList = append(cm.connList, addr)
+		cm.retryList[addr] = MaxRetryCount
+		cm.Unlock()
+	}
+
+	// Other addresses are given up
+	if cm.waitRetry("10.0.0.2:20866") {
+		t.Fatal("address retried after too many failures")
+	}
+
+	// The connect peer waits for the next retry
+	retried := make(chan bool)
+	go func() {
+		retried <- cm.waitRetry("10.0.0.1:20866")
+	}()
+	select {
+	case <-retried:
+		t.Fatal("connect peer given up after too many failures")
+	case <-time.After(time.Millisecond * 100):
+	}
+	addrs := cm.ConnectingAddrs()
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1:20866" {
+		t.Errorf("connecting addresses %v, expect the connect peer", addrs)
+	}
+
+	close(pm.quit)
+	if <-retried {
+		t.Error("connect peer retried after stopped")
+	}
+}
+
+func TestReloadConnectPeers(t *testing.T) {
+	pm, cleanup := newConnectOnlyManager(t, "10.0.0.1:20866", "10.0.0.2:20866")
+	defer cleanup()
+
+	var peers []*Peer
+	for _, addr := range []string{"10.0.0.1:20866", "10.0.0.2:20866"} {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		peer := NewPeer(pm, local, false)
+		peer.addr = addr
+		peer.SetState(p2p.ESTABLISH)
+		pm.runningPeers[peer] = struct{}{}
+		peers = append(peers, peer)
+	}
+
+	// Peers removed from the list are disconnected
+	pm.SetConnectPeers([]string{"10.0.0.1:20866"})
+	if peers[0].State() == p2p.INACTIVITY {
+		t.Error("connect peer disconnected by reload")
+	}
+	if peers[1].State() != p2p.INACTIVITY {
+		t.Error("removed connect peer not disconnected by reload")
+	}
+	if !pm.ConnectOnly() || pm.canDial("10.0.0.3:20866") {
+		t.Error("not in connect only mode after reload")
+	}
+
+	// An empty list leaves connect only mode and keeps the peers
+	pm.SetConnectPeers(nil)
+	if peers[0].State() == p2p.INACTIVITY {
+		t.Error("peer disconnected by leaving connect only mode")
+	}
+	if pm.ConnectOnly() || !pm.canDial("10.0.0.3:20866") {
+		t.Error("still in connect only mode after an empty list reloaded")
+	}
+}
@@ -1,7 +1,7 @@
 package net
 
 import (
-	"net"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -12,8 +12,14 @@ import (
 
 const (
 	ConnTimeOut   = 5
-	RetryDuration = 15
 	MaxRetryCount = 5
+
+	// The wait before the first retry, it doubles on every following retry
+	RetryBaseDuration = time.Second * 5
+	// The max wait between retries
+	MaxRetryDuration = time.Minute * 10
+	// The wait is randomized by up to this fraction, so retries do not happen at the same time
+	RetryJitter = 0.25
 )
 
 type ConnManager struct {
@@ -21,14 +27,24 @@ type ConnManager struct {
 
 	connList  []string
 	retryList map[string]int
+	pm        *PeerManager
+	banList   *BanList
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 
-	OnDiscardAddr func(add string)
+	OnConnectFailed func(addr string)
+	OnDemoteAddr    func(addr string)
 }
 
-func newConnManager(onDiscardAddr func(add string)) *ConnManager {
+func newConnManager(pm *PeerManager) *ConnManager {
 	cm := new(ConnManager)
 	cm.retryList = make(map[string]int)
-	cm.OnDiscardAddr = onDiscardAddr
+	cm.pm = pm
+	cm.banList = pm.banList
+	cm.quit = pm.quit
+	cm.OnConnectFailed = pm.OnConnectFailed
+	cm.OnDemoteAddr = pm.OnDemoteAddr
 	return cm
 }
 
@@ -41,8 +57,47 @@ func (cm *ConnManager) Connect(addr string) {
 		return
 	}
 
+	if cm.banList.IsBanned(addr) {
+		log.Info("ConnManager refuse to connect banned addr,", addr)
+		return
+	}
+
+	select {
+	case <-cm.quit:
+		return
+	default:
+	}
+
 	cm.connList = append(cm.connList, addr)
-	go cm.connectPeer(addr)
+	cm.wg.Add(1)
+	go func() {
+		defer cm.wg.Done()
+		cm.connectPeer(addr)
+	}()
+}
+
+// Wait for the connecting and retrying goroutines to exit,
+// they are cancelled by closing the quit channel.
+func (cm *ConnManager) Stop() {
+	cm.wg.Wait()
+}
+
+// Return the count of addresses connecting or waiting for retry
+func (cm *ConnManager) PendingCount() int {
+	cm.Lock()
+	defer cm.Unlock()
+
+	return len(cm.connList)
+}
+
+// Return the addresses connecting or waiting for retry
+func (cm *ConnManager) ConnectingAddrs() []string {
+	cm.Lock()
+	defer cm.Unlock()
+
+	addrs := make([]string, len(cm.connList))
+	copy(addrs, cm.connList)
+	return addrs
 }
 
 func (cm *ConnManager) inConnList(addr string) bool {
@@ -54,6 +109,7 @@ func (cm *ConnManager) inConnList(addr string) bool {
 	return false
 }
 
+// Remove the address from the connecting list, must be called with cm locked
 func (cm *ConnManager) removeAddrFromConnectingList(addr string) {
 	delete(cm.retryList, addr)
 	for i, connAddr := range cm.connList {
@@ -65,23 +121,39 @@ func (cm *ConnManager) removeAddrFromConnectingList(addr string) {
 }
 
 func (cm *ConnManager) connectPeer(addr string) {
-	conn, err := net.DialTimeout("tcp", addr, time.Second*ConnTimeOut)
-	if err != nil {
-		log.Error("Connect to addr ", addr, " failed, err", err)
-		cm.retry(addr)
-		return
-	}
+	for {
+		if cm.banList.IsBanned(addr) || !cm.pm.canDial(addr) {
+			cm.Lock()
+			cm.removeAddrFromConnectingList(addr)
+			cm.Unlock()
+			return
+		}
 
-	// Start read msg from remote peer
-	remote := NewPeer(conn)
-	remote.SetState(p2p.HAND)
-	go remote.Read()
+		conn, err := cm.pm.dialer.Dial(addr)
+		if err == nil {
+			// Start read msg from remote peer
+			remote := NewPeer(cm.pm, conn, false)
+			// Know the peer by the dialed address, which may be a host name
+			remote.addr = addr
+			remote.SetState(p2p.HAND)
+			cm.pm.runPeer(remote)
+
+			// Send version message to remote peer
+			remote.Send(cm.pm.Local().NewVersionMsg())
+			return
+		}
 
-	// Send version message to remote peer
-	go remote.Send(pm.local.NewVersionMsg())
+		log.Error("Connect to addr ", addr, " failed, err", err)
+		cm.OnConnectFailed(addr)
+		if !cm.waitRetry(addr) {
+			return
+		}
+	}
 }
 
-func (cm *ConnManager) retry(addr string) {
+// Wait for the next retry of the address with exponential backoff, return false
+// if the address should not be retried any more or the manager is stopping.
+func (cm *ConnManager) waitRetry(addr string) bool {
 	cm.Lock()
 	retryTimes, ok := cm.retryList[addr]
 	if !ok {
@@ -89,18 +161,45 @@ func (cm *ConnManager) retry(addr string) {
 	} else {
 		retryTimes += 1
 	}
-	log.Info("Put into retry queue, retry times:", retryTimes)
+	// Connect peers are retried forever
+	if cm.pm.isConnectPeer(addr) && retryTimes > MaxRetryCount {
+		retryTimes = MaxRetryCount
+	}
 	if retryTimes > MaxRetryCount {
 		cm.removeAddrFromConnectingList(addr)
 		cm.Unlock()
-		// Discard useless address
-		cm.OnDiscardAddr(addr)
-		return
+		// Demote the address, so it will be tried again much later
+		cm.OnDemoteAddr(addr)
+		return false
 	}
 	cm.retryList[addr] = retryTimes
 	cm.Unlock()
 
-	log.Info("Wait for retry ", addr)
-	time.Sleep(time.Second * RetryDuration)
-	cm.connectPeer(addr)
+	duration := retryDuration(retryTimes)
+	log.Info("Wait ", duration, " for retry ", addr, ", retry times:", retryTimes)
+	timer := time.NewTimer(duration)
+	select {
+	case <-timer.C:
+		return true
+	case <-cm.quit:
+		timer.Stop()
+		cm.Lock()
+		cm.removeAddrFromConnectingList(addr)
+		cm.Unlock()
+		return false
+	}
+}
+
+// Return the wait before the retry, RetryBaseDuration doubled by retry times
+// and capped by MaxRetryDuration, with a random jitter.
+func retryDuration(retryTimes int) time.Duration {
+	duration := MaxRetryDuration
+	if retryTimes < 16 {
+		duration = RetryBaseDuration << uint(retryTimes)
+		if duration > MaxRetryDuration {
+			duration = MaxRetryDuration
+		}
+	}
+	jitter := (rand.Float64()*2 - 1) * RetryJitter
+	return duration + time.Duration(float64(duration)*jitter)
 }
@@ -0,0 +1,73 @@
+package net
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRetryDuration(t *testing.T) {
+	for retryTimes := 0; retryTimes < 100; retryTimes++ {
+		expect := MaxRetryDuration
+		if retryTimes < 16 && RetryBaseDuration<<uint(retryTimes) < MaxRetryDuration {
+			expect = RetryBaseDuration << uint(retryTimes)
+		}
+		min := time.Duration(float64(expect) * (1 - RetryJitter))
+		max := time.Duration(float64(expect) * (1 + RetryJitter))
+
+		duration := retryDuration(retryTimes)
+		if duration < min || duration > max {
+			t.Errorf("retry %d waits %s, expect between %s and %s", retryTimes, duration, min, max)
+		}
+	}
+}
+
+func TestConnectHostName(t *testing.T) {
+	network := NewPipeNetwork()
+	listener, err := network.Listen("127.0.0.1:20866")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	dataDir, err := ioutil.TempDir("", "connmanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	pm := NewPeerManager(new(Peer), &Config{
+		Dialer:        network,
+		DisableListen: true,
+		ConnectPeers:  []string{"localhost:20866"},
+		DataDir:       dataDir,
+	})
+	pm.ConnectPeer("localhost:20866")
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The peer is known by the dialed host name and not the resolved address
+	var peer *Peer
+	for deadline := time.Now().Add(time.Second); peer == nil && time.Now().Before(deadline); {
+		for _, running := range pm.runningPeerList() {
+			peer = running
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if peer == nil {
+		t.Fatal("dialed peer not running")
+	}
+	if addr := peer.AddrString(); addr != "localhost:20866" {
+		t.Fatalf("peer address %s, expect the dialed address", addr)
+	}
+
+	// So the connecting address is removed by the peer address once connected
+	addrs := pm.connManager.ConnectingAddrs()
+	if len(addrs) != 1 || addrs[0] != peer.AddrString() {
+		t.Errorf("connecting addresses %v, expect the peer address", addrs)
+	}
+}
@@ -0,0 +1,26 @@
+package net
+
+import (
+	"net"
+	"time"
+)
+
+// Dialer makes outbound connections to peer addresses in host:port format
+type Dialer interface {
+	Dial(addr string) (net.Conn, error)
+}
+
+// TCPDialer connects peers directly with TCP
+type TCPDialer struct {
+	// The network to dial, tcp4, tcp6 or tcp, tcp is used if not set
+	Network string
+	Timeout time.Duration
+}
+
+func (d *TCPDialer) Dial(addr string) (net.Conn, error) {
+	network := d.Network
+	if network == "" {
+		network = "tcp"
+	}
+	return net.DialTimeout(network, addr, d.Timeout)
+}
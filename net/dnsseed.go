@@ -0,0 +1,87 @@
+package net
+
+import (
+	"net"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+const (
+	// DNS seeds will not be queried again in this duration
+	DNSSeedInterval = time.Minute * 10
+)
+
+// Resolver looks up the IP addresses of a host name,
+// both IPv4 and IPv6 addresses should be returned.
+type Resolver interface {
+	LookupHost(host string) ([]string, error)
+}
+
+// SystemResolver resolves host names with the system DNS resolver
+type SystemResolver struct{}
+
+func (r *SystemResolver) LookupHost(host string) ([]string, error) {
+	return net.LookupHost(host)
+}
+
+// Query the DNS seeds in background when the address book runs low,
+// every resolved address will be added into the address book.
+func (pm *PeerManager) queryDNSSeeds() {
+	if len(pm.dnsSeeds) == 0 {
+		return
+	}
+
+	pm.dnsLock.Lock()
+	if pm.dnsQuerying || time.Since(pm.lastDNSQuery) < DNSSeedInterval {
+		pm.dnsLock.Unlock()
+		return
+	}
+	pm.dnsQuerying = true
+	pm.lastDNSQuery = time.Now()
+	pm.dnsLock.Unlock()
+
+	pm.wg.Add(1)
+	go func() {
+		defer pm.wg.Done()
+		defer func() {
+			pm.dnsLock.Lock()
+			pm.dnsQuerying = false
+			pm.dnsLock.Unlock()
+		}()
+
+		for _, seed := range pm.dnsSeeds {
+			select {
+			case <-pm.quit:
+				return
+			default:
+			}
+			pm.resolveDNSSeed(seed)
+		}
+	}()
+}
+
+// Resolve the DNS seed in host:port format and add the addresses into the address book
+func (pm *PeerManager) resolveDNSSeed(seed string) {
+	host, port, err := net.SplitHostPort(seed)
+	if err != nil {
+		log.Error("Invalid DNS seed ", seed, ", ", err)
+		return
+	}
+
+	ips, err := pm.resolver.LookupHost(host)
+	if err != nil {
+		log.Error("Query DNS seed ", host, " failed, ", err)
+		return
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+	log.Infof("DNS seed %s returned %d addresses", host, len(addrs))
+
+	for _, addr := range pm.addrManager.NewAddrs(addrs, host) {
+		pm.notifyEvent(EventAddrLearned, nil, addr, host)
+	}
+}
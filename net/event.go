@@ -0,0 +1,102 @@
+package net
+
+import (
+	"time"
+)
+
+// EventType is the type of a peer to peer network event
+type EventType uint8
+
+const (
+	// A peer finished the handshake and has been added to the connected peers
+	EventPeerConnected EventType = iota
+	// A connected peer has been disconnected, see Event.Reason for why
+	EventPeerDisconnected
+	// A connection was closed before the handshake finished
+	EventHandshakeFailed
+	// A new address has been added into the address book
+	EventAddrLearned
+	// An address has been discarded from the address book
+	EventAddrDiscarded
+	// The sync peer changed, Event.Peer is the new sync peer or nil if there is none
+	EventSyncPeerChanged
+	// A peer has been banned for misbehavior
+	EventPeerBanned
+	// An address has been demoted in the address book, it will not be selected
+	// to connect for AddrDemoteDuration
+	EventAddrDemoted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventPeerConnected:
+		return "peer connected"
+	case EventPeerDisconnected:
+		return "peer disconnected"
+	case EventHandshakeFailed:
+		return "handshake failed"
+	case EventAddrLearned:
+		return "address learned"
+	case EventAddrDiscarded:
+		return "address discarded"
+	case EventSyncPeerChanged:
+		return "sync peer changed"
+	case EventPeerBanned:
+		return "peer banned"
+	case EventAddrDemoted:
+		return "address demoted"
+	default:
+		return "unknown event"
+	}
+}
+
+// Event is a peer to peer network event
+type Event struct {
+	Type EventType
+	// The peer this event is about, nil for address events
+	Peer *Peer
+	// The address this event is about in host:port format
+	Addr string
+	// Why the peer has been disconnected, banned, failed the handshake
+	// or the address has been discarded or demoted. For learned addresses, this is
+	// the source which told us about the address. For sync peer changes,
+	// this is the score the new sync peer was chosen by.
+	Reason string
+	// When the event happened, listeners are called in their own goroutines
+	// so they may receive events out of order.
+	Time time.Time
+}
+
+/*
+EventListener is an interface to listen peer to peer network events.
+Call PeerManager.AddEventListener() method to register your callbacks to the notify list.
+*/
+type EventListener interface {
+	// This method will be callback when a peer to peer network event happens
+	OnEvent(event Event)
+}
+
+// Register a network event listener, multiple registration is supported.
+func (pm *PeerManager) AddEventListener(listener EventListener) {
+	pm.listenersLock.Lock()
+	defer pm.listenersLock.Unlock()
+
+	pm.eventListeners = append(pm.eventListeners, listener)
+}
+
+func (pm *PeerManager) notifyEvent(eventType EventType, peer *Peer, addr, reason string) {
+	event := Event{
+		Type:   eventType,
+		Peer:   peer,
+		Addr:   addr,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+
+	pm.listenersLock.RLock()
+	defer pm.listenersLock.RUnlock()
+
+	for _, listener := range pm.eventListeners {
+		go listener.OnEvent(event)
+	}
+}
@@ -0,0 +1,190 @@
+package net
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+const (
+	msgMagicLen    = 4
+	msgCmdLen      = 12
+	msgLengthLen   = 4
+	msgChecksumLen = 4
+	msgHeaderLen   = msgMagicLen + msgCmdLen + msgLengthLen + msgChecksumLen
+
+	// Max payload length of a message, the peer will be disconnected if it sends a larger one
+	MaxPayloadSize = 8 * 1024 * 1024
+
+	// Messages of commands we do not know are counted by this command
+	UnknownCommand = "unknown"
+)
+
+var (
+	errDisconnected     = errors.New("peer disconnected")
+	errUnmatchedMagic   = errors.New("unmatched magic number")
+	errReadTimeout      = errors.New("read timeout")
+	errOversizedPayload = errors.New("payload too large")
+	errRateLimited      = errors.New("message rate limit exceeded")
+	errUnknownCommand   = errors.New("unknown command")
+)
+
+// Handle the decoded messages and decode errors of a message reader
+type readerHandler interface {
+	// A decode error occurred, the reader stops after a disconnect, unmatched magic,
+	// read timeout or oversized payload error, errUnknownCommand is reported if a
+	// message of the command can not be made
+	OnDecodeError(err error)
+
+	// Return the deadline to read the next message, no deadline if it is zero
+	ReadDeadline() time.Time
+
+	// A message has been read with a valid checksum, before it is decoded.
+	// size is the length of the message including the header, cmd is
+	// UnknownCommand if a message of the command can not be made.
+	OnMessageRead(cmd string, size int)
+
+	// Return if a message of the command is allowed by the rate limit,
+	// the message is dropped without decoding if not
+	AllowMessage(cmd string) bool
+
+	// Create a message instance by the given cmd parameter
+	OnMakeMessage(cmd string) (Message, error)
+
+	// A message has been decoded
+	OnMessageDecoded(msg Message)
+}
+
+// msgReader reads messages of the peer to peer network identified by it's magic number
+type msgReader struct {
+	magic   uint32
+	conn    net.Conn
+	handler readerHandler
+}
+
+func newMsgReader(magic uint32, conn net.Conn, handler readerHandler) *msgReader {
+	return &msgReader{magic: magic, conn: conn, handler: handler}
+}
+
+// Read messages from the connection until it is closed
+func (reader *msgReader) Read() {
+	for {
+		msg, err := reader.readMessage()
+		if err != nil {
+			reader.handler.OnDecodeError(err)
+			switch err {
+			case errDisconnected, errUnmatchedMagic, errReadTimeout, errOversizedPayload:
+				return
+			}
+			continue
+		}
+
+		reader.handler.OnMessageDecoded(msg)
+	}
+}
+
+func (reader *msgReader) readMessage() (Message, error) {
+	deadline := reader.handler.ReadDeadline()
+	if !deadline.IsZero() {
+		reader.conn.SetReadDeadline(deadline)
+	}
+
+	header := make([]byte, msgHeaderLen)
+	_, err := io.ReadFull(reader.conn, header)
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	magic := binary.LittleEndian.Uint32(header[:msgMagicLen])
+	if magic != reader.magic {
+		return nil, errUnmatchedMagic
+	}
+
+	offset := msgMagicLen
+	cmd := string(bytes.TrimRight(header[offset:offset+msgCmdLen], "\x00"))
+	offset += msgCmdLen
+	length := binary.LittleEndian.Uint32(header[offset : offset+msgLengthLen])
+	offset += msgLengthLen
+	checksum := header[offset : offset+msgChecksumLen]
+
+	if length > MaxPayloadSize {
+		return nil, errOversizedPayload
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader.conn, payload)
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	if !bytes.Equal(checksum, msgChecksum(payload)) {
+		return nil, fmt.Errorf("unmatched checksum of message %s", cmd)
+	}
+
+	msg, err := reader.handler.OnMakeMessage(cmd)
+	if err != nil {
+		// Made up commands are counted and limited together, or they grow the counters
+		// without bound and a peer can rotate them to get around the rate limit
+		reader.handler.OnMessageRead(UnknownCommand, msgHeaderLen+len(payload))
+		if !reader.handler.AllowMessage(UnknownCommand) {
+			return nil, errRateLimited
+		}
+		return nil, errUnknownCommand
+	}
+
+	reader.handler.OnMessageRead(cmd, msgHeaderLen+len(payload))
+
+	if !reader.handler.AllowMessage(cmd) {
+		return nil, errRateLimited
+	}
+
+	err = msg.Deserialize(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("deserialize message %s failed, %s", cmd, err)
+	}
+
+	return msg, nil
+}
+
+// Tell a read timeout from other errors which mean the connection is closed
+func readError(err error) error {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return errReadTimeout
+	}
+	return errDisconnected
+}
+
+// Serialize the message with a header of the given magic number
+func buildMessage(magic uint32, msg Message) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	err := msg.Serialize(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, msgHeaderLen)
+	offset := 0
+	binary.LittleEndian.PutUint32(header[offset:], magic)
+	offset += msgMagicLen
+	copy(header[offset:offset+msgCmdLen], msg.CMD())
+	offset += msgCmdLen
+	binary.LittleEndian.PutUint32(header[offset:], uint32(payload.Len()))
+	offset += msgLengthLen
+	copy(header[offset:], msgChecksum(payload.Bytes()))
+
+	return append(header, payload.Bytes()...), nil
+}
+
+// The first 4 bytes of the double SHA256 hash of the payload
+func msgChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:msgChecksumLen]
+}
@@ -0,0 +1,187 @@
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p"
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p/msg"
+)
+
+// A message of any command with a raw payload
+type testMessage struct {
+	cmd     string
+	payload []byte
+}
+
+func (m *testMessage) CMD() string {
+	return m.cmd
+}
+
+func (m *testMessage) Serialize(w io.Writer) error {
+	_, err := w.Write(m.payload)
+	return err
+}
+
+func (m *testMessage) Deserialize(r io.Reader) error {
+	payload, err := ioutil.ReadAll(r)
+	m.payload = payload
+	return err
+}
+
+// A message handler knowing no commands
+type testMsgHandler struct{}
+
+func (h testMsgHandler) MakeMessage(cmd string) (Message, error) {
+	return nil, errors.New("unknown command " + cmd)
+}
+
+func (h testMsgHandler) OnHandshake(v *Version) error {
+	return nil
+}
+
+func (h testMsgHandler) OnPeerEstablish(*Peer) {}
+
+func (h testMsgHandler) HandleMessage(*Peer, Message) error {
+	return nil
+}
+
+// Serialize the messages with headers of the test network
+func buildTestMessages(t *testing.T, msgs []Message) [][]byte {
+	var data [][]byte
+	for _, msg := range msgs {
+		buf, err := buildMessage(0, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf)
+	}
+	return data
+}
+
+// Read the data sent to a new peer until the connection is closed
+func readTestMessages(t *testing.T, config *Config, data [][]byte) (*PeerManager, *Peer) {
+	dataDir, err := ioutil.TempDir("", "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	config.DisableListen = true
+	config.DataDir = dataDir
+	pm := NewPeerManager(new(Peer), config)
+	pm.SetMessageHandler(testMsgHandler{})
+
+	local, remote := net.Pipe()
+	peer := NewPeer(pm, local, false)
+	done := make(chan struct{})
+	go func() {
+		peer.Read()
+		close(done)
+	}()
+
+	for _, buf := range data {
+		// The peer may be disconnected for the messages
+		_, err = remote.Write(buf)
+		if err != nil {
+			break
+		}
+	}
+	remote.Close()
+	<-done
+	return pm, peer
+}
+
+func TestUnknownCommandTraffic(t *testing.T) {
+	msgs := make([]Message, 50)
+	for i := range msgs {
+		msgs[i] = &testMessage{cmd: fmt.Sprint("cmd", i), payload: []byte{byte(i)}}
+	}
+	pm, peer := readTestMessages(t, new(Config), buildTestMessages(t, msgs))
+
+	// Made up commands are counted together
+	for _, traffic := range []TrafficStats{peer.Traffic(), pm.Traffic()} {
+		if len(traffic.Commands) != 1 {
+			t.Errorf("traffic counted by %d commands, expect 1", len(traffic.Commands))
+		}
+		stats := traffic.Commands[UnknownCommand]
+		if stats.MsgsRecv != uint64(len(msgs)) || stats.BytesRecv != uint64(len(msgs)*(msgHeaderLen+1)) {
+			t.Errorf("unknown commands counted %d messages %d bytes, expect %d messages %d bytes",
+				stats.MsgsRecv, stats.BytesRecv, len(msgs), len(msgs)*(msgHeaderLen+1))
+		}
+	}
+}
+
+func TestUnknownCommandLimit(t *testing.T) {
+	msgs := make([]Message, 30)
+	for i := range msgs {
+		msgs[i] = &testMessage{cmd: fmt.Sprint("cmd", i)}
+	}
+	pm, peer := readTestMessages(t, &Config{
+		MessageRateLimits: map[string]RateLimit{UnknownCommand: {Rate: 0.001, Burst: 10}},
+	}, buildTestMessages(t, msgs))
+
+	// Distinct unknown commands share one token bucket
+	if len(peer.limiter.buckets) != 1 {
+		t.Errorf("%d token buckets, expect 1", len(peer.limiter.buckets))
+	}
+
+	// Each unknown command is an offense, and a flood over the limit
+	expect := 10*OffenseUnknownCommand.Weight + 20*OffenseMessageFlood.Weight
+	if score := pm.BanScore(peer); score < expect-1 || score > expect {
+		t.Errorf("ban score %d, expect %d", score, expect)
+	}
+}
+
+func TestOversizedMessage(t *testing.T) {
+	// Only the header is sent, the payload length is checked before reading it
+	header := make([]byte, msgHeaderLen)
+	copy(header[msgMagicLen:], "tx")
+	binary.LittleEndian.PutUint32(header[msgMagicLen+msgCmdLen:], MaxPayloadSize+1)
+	pm, peer := readTestMessages(t, new(Config), [][]byte{header})
+
+	// The score decays a little since added
+	if score := pm.BanScore(peer); score < OffenseOversizedMessage.Weight-1 || score > OffenseOversizedMessage.Weight {
+		t.Errorf("ban score %d, expect %d", score, OffenseOversizedMessage.Weight)
+	}
+	if peer.State() != INACTIVITY {
+		t.Error("peer sent an oversized message not disconnected")
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+	pm := NewPeerManager(new(Peer), &Config{DisableListen: true, DataDir: dataDir})
+	pm.SetMessageHandler(testMsgHandler{})
+
+	// The peer connects but never sends the version and verack
+	local, remote := net.Pipe()
+	defer remote.Close()
+	peer := NewPeer(pm, local, false)
+	peer.handshakeDeadline = time.Now().Add(time.Millisecond * 100)
+	done := make(chan struct{})
+	go func() {
+		peer.Read()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("peer not dropped at the handshake deadline")
+	}
+	if peer.State() != INACTIVITY {
+		t.Error("peer not disconnected at the handshake deadline")
+	}
+}
@@ -0,0 +1,125 @@
+package net
+
+import (
+	"math"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+)
+
+const (
+	// A peer will be banned when it's ban score reaches this value
+	BanThreshold = 100
+
+	// Ban score decays by half in this duration
+	BanScoreHalfLife = time.Minute * 10
+)
+
+// Offense is a named misbehavior of a peer and the ban score it adds
+type Offense struct {
+	Name   string
+	Weight uint32
+}
+
+var (
+	// Peer sent an inventory or merkle block we are not expecting
+	OffenseUnexpectedBlock = Offense{"unexpected block", 20}
+
+	// Peer sent block data while it is not the sync peer
+	OffenseNonSyncPeerData = Offense{"data from non sync peer", 20}
+
+	// Peer sent a header not satisfying it's proof of work
+	OffenseInvalidProofOfWork = Offense{"invalid proof of work", 100}
+
+	// Peer sent a merkle block with invalid merkle proof
+	OffenseInvalidMerkleBlock = Offense{"invalid merkle block", 100}
+
+	// Peer sent a header breaking the difficulty or timestamp rules of the chain it extends
+	OffenseInvalidHeader = Offense{"invalid header", 100}
+
+	// Peer sent a header conflicting with a checkpoint
+	OffenseCheckpointMismatch = Offense{"checkpoint mismatch", 100}
+
+	// Peer sent a message with payload larger than MaxPayloadSize
+	OffenseOversizedMessage = Offense{"oversized message", 50}
+
+	// Peer sent a message over the rate limit of it's command
+	OffenseMessageFlood = Offense{"message flood", 2}
+
+	// Peer sent a message of a command we do not know
+	OffenseUnknownCommand = Offense{"unknown command", 1}
+)
+
+// The decaying ban score of a host
+type banScore struct {
+	score      float64
+	lastUpdate time.Time
+}
+
+func (s *banScore) current(now time.Time) float64 {
+	elapsed := now.Sub(s.lastUpdate).Seconds()
+	return s.score * math.Pow(0.5, elapsed/BanScoreHalfLife.Seconds())
+}
+
+func (s *banScore) increase(weight uint32, now time.Time) float64 {
+	s.score = s.current(now) + float64(weight)
+	s.lastUpdate = now
+	return s.score
+}
+
+// Add ban score to the peer by the offense it made, the peer will be
+// disconnected and banned once it's score reaches BanThreshold.
+// Return true if the peer has been banned.
+func (pm *PeerManager) Misbehave(peer *Peer, offense Offense) bool {
+	addr := peer.AddrString()
+	host := hostOf(addr)
+
+	pm.scoresLock.Lock()
+	now := time.Now()
+	score, ok := pm.banScores[host]
+	if !ok {
+		score = new(banScore)
+		pm.banScores[host] = score
+	}
+	current := score.increase(offense.Weight, now)
+
+	// Remove decayed scores
+	for h, s := range pm.banScores {
+		if s.current(now) < 1 {
+			delete(pm.banScores, h)
+		}
+	}
+
+	banned := current >= BanThreshold
+	if banned {
+		delete(pm.banScores, host)
+	}
+	pm.scoresLock.Unlock()
+
+	log.Warnf("Peer %d misbehaved: %s, ban score %.0f", peer.ID(), offense.Name, current)
+	if !banned {
+		return false
+	}
+
+	pm.banList.Ban(addr, DefaultBanDuration, offense.Name)
+	pm.notifyEvent(EventPeerBanned, peer, addr, offense.Name)
+	pm.DisconnectPeerWithReason(peer, "banned for "+offense.Name)
+	return true
+}
+
+// Return current ban score of the peer
+func (pm *PeerManager) BanScore(peer *Peer) uint32 {
+	pm.scoresLock.Lock()
+	defer pm.scoresLock.Unlock()
+
+	score, ok := pm.banScores[hostOf(peer.AddrString())]
+	if !ok {
+		return 0
+	}
+	return uint32(score.current(time.Now()))
+}
+
+// Return the ban list of the peer manager
+func (pm *PeerManager) BanList() *BanList {
+	return pm.banList
+}
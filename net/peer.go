@@ -3,8 +3,7 @@ package net
 import (
 	"fmt"
 	"net"
-	"strings"
-	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
@@ -13,6 +12,19 @@ import (
 	. "github.com/wuyazero/Elastos.ELA.Utility/p2p/msg"
 )
 
+const (
+	// Max messages waiting in the send queue, the peer will be disconnected when overflow
+	MaxSendQueueSize = 512
+	// Max control messages waiting in the priority send queue
+	MaxCtrlQueueSize = 16
+	// Timeout of writing a message to the peer
+	WriteTimeout = time.Second * 30
+	// The peer will be disconnected if it does not finish handshake in this duration after connected
+	HandshakeTimeout = time.Second * 30
+	// The peer will be disconnected if nothing is received from it in this duration
+	ReadIdleTimeout = time.Minute * 2
+)
+
 type Peer struct {
 	// info
 	id         uint64
@@ -20,14 +32,48 @@ type Peer struct {
 	services   uint64
 	ip16       [16]byte
 	port       uint16
+	addr       string
 	lastActive time.Time
 	height     uint64
 	relay      uint8 // 1 for true 0 for false
+	inbound    bool
 
 	PeerState
 	conn net.Conn
 
-	reader *MsgReader
+	pm     *PeerManager
+	reader *msgReader
+
+	// keepalive
+	statsLock sync.RWMutex
+	lastSend  time.Time
+	lastPing  time.Time
+	pingTime  time.Time
+	rtt       time.Duration
+
+	// sync peer score, see PeerScore
+	requested    uint32
+	delivered    uint32
+	timeouts     uint32
+	syncFailures uint32
+
+	sendQueue   chan Message
+	ctrlQueue   chan Message
+	quit        chan struct{}
+	disconnect  sync.Once
+	reason      string
+	established bool
+
+	// Deadline of the handshake, only accessed by the read goroutine
+	handshakeDeadline time.Time
+
+	traffic trafficCounter
+
+	// Message rate limits, only accessed by the read goroutine
+	limiter *rateLimiter
+
+	// Last time the peer requested addresses, only accessed by the read goroutine
+	lastAddrsReq time.Time
 }
 
 func (peer *Peer) String() string {
@@ -36,32 +82,35 @@ func (peer *Peer) String() string {
 		"\n\tVersion:", peer.version,
 		"\n\tServices:", peer.services,
 		"\n\tPort:", peer.port,
-		"\n\tLastActive:", peer.lastActive,
+		"\n\tLastActive:", peer.LastActive(),
+		"\n\tRTT:", peer.RTT(),
 		"\n\tHeight:", peer.height,
 		"\n\tRelay:", peer.relay,
 		"\n\tState:", peer.PeerState.String(),
-		"\n\tAddr:", peer.Addr().String(),
+		"\n\tDirection:", peer.Direction(),
+		"\n\tAddr:", peer.AddrString(),
 		"\n}")
 }
 
-func NewPeer(conn net.Conn) *Peer {
+func NewPeer(pm *PeerManager, conn net.Conn, inbound bool) *Peer {
 	peer := new(Peer)
 	peer.conn = conn
+	peer.pm = pm
+	peer.inbound = inbound
+	peer.addr = normalizeAddr(conn.RemoteAddr().String())
 	peer.ip16, peer.port = addrFromConn(conn)
-	peer.reader = NewMsgReader(conn, peer)
+	peer.reader = newMsgReader(pm.magic, conn, peer)
+	peer.sendQueue = make(chan Message, MaxSendQueueSize)
+	peer.ctrlQueue = make(chan Message, MaxCtrlQueueSize)
+	peer.quit = make(chan struct{})
+	peer.handshakeDeadline = time.Now().Add(HandshakeTimeout)
+	peer.limiter = newRateLimiter(pm.rateLimits)
 	return peer
 }
 
 func addrFromConn(conn net.Conn) ([16]byte, uint16) {
-	addr := conn.RemoteAddr().String()
-	portIndex := strings.LastIndex(addr, ":")
-	port, _ := strconv.ParseUint(string([]byte(addr)[portIndex+1:]), 10, 16)
-	ip := net.ParseIP(string([]byte(addr)[:portIndex])).To16()
-
-	ip16 := [16]byte{}
-	copy(ip16[:], ip[:])
-
-	return ip16, uint16(port)
+	ip16, port, _ := splitAddr(conn.RemoteAddr().String())
+	return ip16, port
 }
 
 func (peer *Peer) ID() uint64 {
@@ -101,13 +150,95 @@ func (peer *Peer) SetPort(port uint16) {
 }
 
 func (peer *Peer) LastActive() time.Time {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
 	return peer.lastActive
 }
 
+// Return the time of the last message written to the peer
+func (peer *Peer) LastSend() time.Time {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
+	return peer.lastSend
+}
+
+// Return the time of the last message received from the peer
+func (peer *Peer) LastRecv() time.Time {
+	return peer.LastActive()
+}
+
+// Return the round trip time measured by the last answered ping,
+// zero if no ping has been answered yet
+func (peer *Peer) RTT() time.Duration {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
+	return peer.rtt
+}
+
+// Return the time the first ping not answered yet was sent, zero if all pings have been answered
+func (peer *Peer) PingTime() time.Time {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
+	return peer.pingTime
+}
+
+// Return the time the last ping was sent, answered or not
+func (peer *Peer) LastPing() time.Time {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
+	return peer.lastPing
+}
+
+// Mark a ping has been sent to the peer.
+// Ping and pong messages carry only the block height and no nonce to
+// match them with, so the RTT is measured by the last ping, pings still
+// waiting for their pongs are given up but PingTime keeps the first of them.
+func (peer *Peer) StartPing() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	peer.lastPing = time.Now()
+	if peer.pingTime.IsZero() {
+		peer.pingTime = peer.lastPing
+	}
+}
+
+// Measure the round trip time of the last ping when the pong arrives, all pings are answered
+func (peer *Peer) OnPong() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	if peer.pingTime.IsZero() {
+		return
+	}
+	peer.rtt = time.Since(peer.lastPing)
+	peer.pingTime = time.Time{}
+}
+
+// Return if the peer connected to us, otherwise we connected to the peer
+func (peer *Peer) Inbound() bool {
+	return peer.inbound
+}
+
+// Return the connection direction of the peer, inbound or outbound
+func (peer *Peer) Direction() string {
+	if peer.inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
 func (peer *Peer) Addr() *Addr {
 	return NewPeerAddr(peer.services, peer.ip16, peer.port, peer.id)
 }
 
+// Return the address of the peer in host:port format, it is the dialed
+// address of an outbound peer, which may be a host name.
+func (peer *Peer) AddrString() string {
+	if peer.addr == "" {
+		return joinAddr(peer.ip16, peer.port)
+	}
+	return peer.addr
+}
+
 func (peer *Peer) Relay() uint8 {
 	return peer.relay
 }
@@ -117,17 +248,23 @@ func (peer *Peer) SetRelay(relay uint8) {
 }
 
 func (peer *Peer) Disconnect() {
-	if peer.State() != INACTIVITY {
+	peer.disconnectWith("disconnected by local peer")
+}
+
+// Close the connection of the peer, only the reason of the first call is kept
+func (peer *Peer) disconnectWith(reason string) {
+	peer.disconnect.Do(func() {
+		peer.reason = reason
 		peer.SetState(INACTIVITY)
 		peer.conn.Close()
-	}
+		close(peer.quit)
+	})
 }
 
 func (peer *Peer) SetInfo(msg *Version) {
 	peer.id = msg.Nonce
 	peer.version = msg.Version
 	peer.services = msg.Services
-	peer.lastActive = time.Now()
 	peer.height = msg.Height
 	peer.relay = msg.Relay
 }
@@ -142,43 +279,144 @@ func (peer *Peer) Height() uint64 {
 
 func (peer *Peer) OnDecodeError(err error) {
 	switch err {
-	case ErrDisconnected:
-		pm.DisconnectPeer(peer)
-	case ErrUnmatchedMagic:
-		log.Error("Decode message error:", ErrUnmatchedMagic)
-		peer.Disconnect()
+	case errDisconnected:
+		peer.pm.DisconnectPeerWithReason(peer, "connection closed")
+	case errUnmatchedMagic:
+		log.Error("Decode message error:", errUnmatchedMagic)
+		peer.disconnectWith("unmatched magic number")
+	case errReadTimeout:
+		if peer.established {
+			peer.pm.DisconnectPeerWithReason(peer, "read timeout")
+		} else {
+			peer.pm.DisconnectPeerWithReason(peer, "handshake timeout")
+		}
+	case errOversizedPayload:
+		log.Error("Peer ", peer.ID(), " sent a message larger than ", MaxPayloadSize, " bytes")
+		peer.pm.Misbehave(peer, OffenseOversizedMessage)
+		peer.pm.DisconnectPeerWithReason(peer, "payload too large")
+	case errRateLimited:
+		peer.pm.Misbehave(peer, OffenseMessageFlood)
+	case errUnknownCommand:
+		peer.pm.Misbehave(peer, OffenseUnknownCommand)
 	default:
 		log.Error(err, ", peer id is: ", peer.ID())
 	}
 }
 
+// The read deadline is ReadIdleTimeout from now, or the ping timeout if it is longer,
+// and no later than the handshake deadline before established
+func (peer *Peer) ReadDeadline() time.Time {
+	idle := ReadIdleTimeout
+	if timeout := peer.pm.PingTimeout(); timeout > idle {
+		idle = timeout
+	}
+	deadline := time.Now().Add(idle)
+	if !peer.established && peer.handshakeDeadline.Before(deadline) {
+		return peer.handshakeDeadline
+	}
+	return deadline
+}
+
+func (peer *Peer) OnMessageRead(cmd string, size int) {
+	peer.traffic.recv(cmd, size)
+	peer.pm.traffic.recv(cmd, size)
+}
+
+func (peer *Peer) AllowMessage(cmd string) bool {
+	if peer.limiter.allow(cmd, time.Now()) {
+		return true
+	}
+	log.Debug("Drop message ", cmd, " from peer ", peer.ID(), ", rate limit exceeded")
+	return false
+}
+
 func (peer *Peer) OnMakeMessage(cmd string) (Message, error) {
-	return pm.makeMessage(cmd)
+	msg, err := peer.pm.makeMessage(cmd)
+	if err != nil {
+		log.Debug("Peer ", peer.ID(), " sent message of unknown command ", cmd, ", ", err)
+	}
+	return msg, err
 }
 
 func (peer *Peer) OnMessageDecoded(msg Message) {
-	pm.handleMessage(peer, msg)
+	peer.statsLock.Lock()
+	peer.lastActive = time.Now()
+	peer.statsLock.Unlock()
+
+	// Capture the message before the replies it causes
+	peer.pm.captureRecv(peer, msg)
+	peer.pm.handleMessage(peer, msg)
 }
 
 func (peer *Peer) Read() {
 	peer.reader.Read()
 }
 
+// Put the message into the send queue of the peer, this method will not block.
+// Control messages like version, verack, ping and pong are sent before other messages,
+// and the peer will be disconnected if it's send queue overflows.
 func (peer *Peer) Send(msg Message) {
 	if peer.State() == INACTIVITY {
 		return
 	}
 
-	buf, err := BuildMessage(msg)
-	if err != nil {
-		log.Error("Serialize message failed, ", err)
-		return
+	queue := peer.sendQueue
+	if isCtrlMessage(msg) {
+		queue = peer.ctrlQueue
 	}
 
-	_, err = peer.conn.Write(buf)
-	if err != nil {
-		log.Error("Error sending message to peer ", err)
-		pm.DisconnectPeer(peer)
+	select {
+	case queue <- msg:
+	default:
+		log.Error("Send queue overflow, disconnect peer ", peer.ID())
+		peer.disconnectWith("send queue overflow")
+	}
+}
+
+func isCtrlMessage(msg Message) bool {
+	switch msg.CMD() {
+	case "version", "verack", "ping", "pong":
+		return true
+	}
+	return false
+}
+
+// Write messages in the send queues to the connection until the peer disconnected
+func (peer *Peer) writeHandler() {
+	for {
+		var msg Message
+		select {
+		case msg = <-peer.ctrlQueue:
+		default:
+			select {
+			case msg = <-peer.ctrlQueue:
+			case msg = <-peer.sendQueue:
+			case <-peer.quit:
+				return
+			}
+		}
+
+		buf, err := buildMessage(peer.pm.magic, msg)
+		if err != nil {
+			log.Error("Serialize message failed, ", err)
+			continue
+		}
+
+		peer.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+		_, err = peer.conn.Write(buf)
+		if err != nil {
+			log.Error("Error sending message to peer ", err)
+			peer.disconnectWith("write error: " + err.Error())
+			return
+		}
+
+		peer.statsLock.Lock()
+		peer.lastSend = time.Now()
+		peer.statsLock.Unlock()
+
+		peer.traffic.sent(msg.CMD(), len(buf))
+		peer.pm.traffic.sent(msg.CMD(), len(buf))
+		peer.pm.captureMsg(CaptureSent, peer.ID(), msg.CMD(), buf[msgHeaderLen:])
 	}
 }
 
@@ -0,0 +1,75 @@
+package net
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingConfig(t *testing.T) {
+	tests := []struct {
+		config   Config
+		interval time.Duration
+		timeout  time.Duration
+	}{
+		{Config{}, DefaultPingInterval, DefaultPingTimeout},
+		{Config{PingInterval: time.Second, PingTimeout: time.Hour}, time.Second, time.Hour},
+	}
+	for _, test := range tests {
+		test.config.DisableListen = true
+		pm := NewPeerManager(new(Peer), &test.config)
+		if interval := pm.PingInterval(); interval != test.interval {
+			t.Errorf("ping interval %s, expect %s", interval, test.interval)
+		}
+		if timeout := pm.PingTimeout(); timeout != test.timeout {
+			t.Errorf("ping timeout %s, expect %s", timeout, test.timeout)
+		}
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	// The read deadline does not cut the peer before the ping timeout
+	pm := &PeerManager{pingTimeout: time.Hour}
+	peer := NewPeer(pm, local, false)
+	peer.established = true
+	if deadline := peer.ReadDeadline(); time.Until(deadline) < time.Minute*59 {
+		t.Errorf("read deadline in %s, expect the ping timeout", time.Until(deadline))
+	}
+
+	pm.pingTimeout = time.Second
+	if deadline := peer.ReadDeadline(); time.Until(deadline) < ReadIdleTimeout-time.Minute {
+		t.Errorf("read deadline in %s, expect ReadIdleTimeout", time.Until(deadline))
+	}
+}
+
+func TestPingTime(t *testing.T) {
+	peer := new(Peer)
+	if !peer.PingTime().IsZero() {
+		t.Fatal("ping time set before a ping sent")
+	}
+
+	// The first unanswered ping is kept, so a peer never answering is timed out
+	peer.StartPing()
+	first := peer.PingTime()
+	time.Sleep(time.Millisecond * 10)
+	peer.StartPing()
+	if ping := peer.PingTime(); !ping.Equal(first) {
+		t.Errorf("ping time %s, expect the first unanswered ping %s", ping, first)
+	}
+	if !peer.LastPing().After(first) {
+		t.Error("last ping not updated by the second ping")
+	}
+
+	// The RTT is measured by the last ping
+	peer.OnPong()
+	if !peer.PingTime().IsZero() {
+		t.Error("ping time not cleared by the pong")
+	}
+	if rtt := peer.RTT(); rtt <= 0 || rtt >= time.Since(first) {
+		t.Errorf("RTT %s, expect measured by the last ping", rtt)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
@@ -13,10 +17,24 @@ import (
 )
 
 const (
-	MinConnCount       = 4
-	InfoUpdateDuration = 5
-	KeepAliveTimeout   = 3
-	MaxOutboundCount   = 6
+	MinConnCount        = 4
+	InfoUpdateDuration  = 5
+	KeepAliveTimeout    = 3
+	MaxOutboundCount    = 6
+	MaxInboundCount     = 8
+	MaxPerIPCount       = 2
+	MaxPerNetGroupCount = 2
+
+	// Max addresses in an addr message, extra addresses will be ignored
+	MaxAddrsPerMsg = 1000
+	// Max addresses replied to a getaddr message
+	MaxAddrsReply = 250
+	// A peer can only get addresses from us once in this duration
+	AddrsReqInterval = time.Minute * 10
+	// Send a ping to each peer in this duration, if not set in the config
+	DefaultPingInterval = time.Minute * 2
+	// Disconnect a peer sent nothing or not answered a ping in this duration, if not set in the config
+	DefaultPingTimeout = time.Minute * 5
 )
 
 // Handle the message creation, allocation etc.
@@ -35,129 +53,544 @@ type MessageHandler interface {
 	HandleMessage(*Peer, Message) error
 }
 
-var pm *PeerManager
-
 type PeerManager struct {
 	*Peers
+	magic       uint32
+	dialer      Dialer
 	addrManager *AddrManager
 	connManager *ConnManager
+	banList     *BanList
 	msgHandler  MessageHandler
+
+	traffic trafficCounter
+	capture *CaptureWriter
+
+	listenersLock  sync.RWMutex
+	eventListeners []EventListener
+
+	ipPreference IPPreference
+	dnsSeeds     []string
+	resolver     Resolver
+	dnsLock      sync.Mutex
+	dnsQuerying  bool
+	lastDNSQuery time.Time
+
+	connectLock  sync.RWMutex
+	connectOnly  bool
+	connectAddrs []string
+
+	scoresLock sync.Mutex
+	banScores  map[string]*banScore
+
+	runningLock  sync.Mutex
+	runningPeers map[*Peer]struct{}
+	listener     net.Listener
+	listen       bool
+	maxInbound   int
+	maxOutbound  int
+	maxPerIP     int
+	maxPerGroup  int
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+
+	rateLimits map[string]RateLimit
+
+	quit chan struct{}
+	wg   sync.WaitGroup
 }
 
-func InitPeerManager(localPeer *Peer, seeds []string) *PeerManager {
+// Create a PeerManager of the peer to peer network identified by the config magic number
+func NewPeerManager(localPeer *Peer, config *Config) *PeerManager {
+	magic := config.Magic
+
 	// Initiate PeerManager
-	pm = new(PeerManager)
+	pm := new(PeerManager)
+	pm.magic = magic
 	pm.Peers = newPeers(localPeer)
-	pm.addrManager = newAddrManager(seeds)
-	pm.connManager = newConnManager(pm.OnDiscardAddr)
+	pm.Peers.onSyncPeerChanged = func(peer *Peer, reason string) {
+		if peer != nil {
+			log.Info("Sync peer changed to ", peer.ID(), " ", peer.AddrString(), ", ", reason)
+		}
+		pm.notifyEvent(EventSyncPeerChanged, peer, "", reason)
+	}
+	pm.dialer = config.Dialer
+	if pm.dialer == nil {
+		pm.dialer = &TCPDialer{
+			Network: config.IPPreference.network(),
+			Timeout: time.Second * ConnTimeOut,
+		}
+	}
+	pm.ipPreference = config.IPPreference
+	pm.dnsSeeds = config.DNSSeeds
+	pm.resolver = config.Resolver
+	if pm.resolver == nil {
+		if config.Dialer != nil && len(pm.dnsSeeds) > 0 {
+			log.Warn("DNS seeds ignored, set a Resolver to resolve them with a custom Dialer")
+			pm.dnsSeeds = nil
+		}
+		pm.resolver = new(SystemResolver)
+	}
+	pm.listener = config.Listener
+	pm.listen = !config.DisableListen
+	pm.maxInbound = config.MaxInbound
+	if pm.maxInbound <= 0 {
+		pm.maxInbound = MaxInboundCount
+	}
+	pm.maxOutbound = config.MaxOutbound
+	if pm.maxOutbound <= 0 {
+		pm.maxOutbound = MaxOutboundCount
+	}
+	pm.maxPerIP = config.MaxPerIP
+	if pm.maxPerIP <= 0 {
+		pm.maxPerIP = MaxPerIPCount
+	}
+	pm.pingInterval = config.PingInterval
+	pm.pingTimeout = config.PingTimeout
+	pm.maxPerGroup = config.MaxPerNetGroup
+	if pm.maxPerGroup == 0 {
+		pm.maxPerGroup = MaxPerNetGroupCount
+	}
+	pm.rateLimits = mergeRateLimits(config.MessageRateLimits)
+	if len(config.DataDir) > 0 {
+		if err := os.MkdirAll(config.DataDir, 0700); err != nil {
+			log.Error("Create data directory failed, ", err)
+		}
+	}
+	pm.banList = newBanList(networkFile(config.DataDir, magic, BannedFile))
+	pm.banScores = make(map[string]*banScore)
+	pm.runningPeers = make(map[*Peer]struct{})
+	pm.quit = make(chan struct{})
+	pm.addrManager = newAddrManager(networkFile(config.DataDir, magic, AddrsFile), config.SeedList, pm.banList, pm.ipPreference, pm.maxPerGroup)
+	pm.connManager = newConnManager(pm)
+	if len(config.ConnectPeers) > 0 {
+		pm.SetConnectPeers(config.ConnectPeers)
+	}
+	if len(config.CaptureFile) > 0 {
+		pm.openCapture(config.CaptureFile)
+	}
 	return pm
 }
 
+// Files are named by the magic number, so peer managers of different networks will not share them
+func networkFile(dataDir string, magic uint32, name string) string {
+	return filepath.Join(dataDir, fmt.Sprint(magic, "_", name))
+}
+
+// Return the magic number of the peer to peer network
+func (pm *PeerManager) Magic() uint32 {
+	return pm.magic
+}
+
+// Return how often a ping is sent to each peer
+func (pm *PeerManager) PingInterval() time.Duration {
+	if pm.pingInterval <= 0 {
+		return DefaultPingInterval
+	}
+	return pm.pingInterval
+}
+
+// Return how long a peer can send nothing before it is disconnected
+func (pm *PeerManager) PingTimeout() time.Duration {
+	if pm.pingTimeout <= 0 {
+		return DefaultPingTimeout
+	}
+	return pm.pingTimeout
+}
+
 func (pm *PeerManager) SetMessageHandler(msgHandler MessageHandler) {
 	pm.msgHandler = msgHandler
 }
 
 func (pm *PeerManager) Start() {
 	log.Info("PeerManager start")
+	pm.wg.Add(2)
 	go pm.keepConnections()
-	go pm.listenConnection()
+	go pm.flushAddrs()
+	if pm.listen {
+		pm.wg.Add(1)
+		go pm.listenConnection()
+	}
+}
+
+// Stop closes the listener, cancels pending connections, disconnects
+// all peers and waits for all the goroutines to exit.
+func (pm *PeerManager) Stop() {
+	select {
+	case <-pm.quit:
+		return
+	default:
+		close(pm.quit)
+	}
+
+	pm.runningLock.Lock()
+	if pm.listener != nil {
+		pm.listener.Close()
+	}
+	peers := make([]*Peer, 0, len(pm.runningPeers))
+	for peer := range pm.runningPeers {
+		peers = append(peers, peer)
+	}
+	pm.runningLock.Unlock()
+
+	// Wait for pending connections
+	pm.connManager.Stop()
+
+	// Disconnect all peers
+	for _, peer := range peers {
+		pm.DisconnectPeerWithReason(peer, "peer manager stopped")
+	}
+
+	pm.wg.Wait()
+	pm.addrManager.flush()
+	if pm.capture != nil {
+		pm.capture.Close()
+	}
+	log.Info("PeerManager stopped")
+}
+
+// Start reading and writing messages of the peer, the peer will be tracked until it disconnected
+func (pm *PeerManager) runPeer(peer *Peer) {
+	pm.runningLock.Lock()
+	defer pm.runningLock.Unlock()
+
+	select {
+	case <-pm.quit:
+		peer.Disconnect()
+		return
+	default:
+	}
+
+	if err := pm.checkConnLimits(peer); err != nil {
+		log.Info("Refuse peer connection, remote: ", peer.conn.RemoteAddr(), ", ", err)
+		peer.disconnectWith(err.Error())
+		pm.connectingDone(peer)
+		pm.notifyEvent(EventHandshakeFailed, peer, peer.AddrString(), err.Error())
+		return
+	}
+
+	pm.runningPeers[peer] = struct{}{}
+	pm.wg.Add(2)
+	go func() {
+		defer pm.wg.Done()
+		peer.writeHandler()
+	}()
+	go func() {
+		defer pm.wg.Done()
+		peer.Read()
+		peer.disconnectWith("connection closed")
+
+		if !peer.established {
+			pm.connectingDone(peer)
+		}
+
+		pm.runningLock.Lock()
+		delete(pm.runningPeers, peer)
+		pm.runningLock.Unlock()
+
+		if peer.established {
+			pm.notifyEvent(EventPeerDisconnected, peer, peer.AddrString(), peer.reason)
+		} else {
+			pm.notifyEvent(EventHandshakeFailed, peer, peer.AddrString(), peer.reason)
+		}
+	}()
+}
+
+// Remove the address of an outbound peer failed before established
+// from the connecting list, so it can be connected again
+func (pm *PeerManager) connectingDone(peer *Peer) {
+	if peer.inbound {
+		return
+	}
+	pm.connManager.Lock()
+	pm.connManager.removeAddrFromConnectingList(peer.AddrString())
+	pm.connManager.Unlock()
+}
+
+// Check the inbound, outbound and per IP connection limits, must be called with runningLock held
+func (pm *PeerManager) checkConnLimits(peer *Peer) error {
+	var inbound, outbound, sameIP int
+	for p := range pm.runningPeers {
+		if p.inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+		if hostOf(p.AddrString()) == hostOf(peer.AddrString()) {
+			sameIP++
+		}
+	}
+
+	if peer.inbound && inbound >= pm.maxInbound {
+		return errors.New("max inbound connections reached")
+	}
+	if !peer.inbound && outbound >= pm.maxOutbound {
+		return errors.New("max outbound connections reached")
+	}
+	if sameIP >= pm.maxPerIP {
+		return errors.New("max connections per IP reached")
+	}
+	return nil
+}
+
+// Return the count of inbound and outbound connections, including peers in handshake
+func (pm *PeerManager) ConnCount() (inbound, outbound int) {
+	pm.runningLock.Lock()
+	defer pm.runningLock.Unlock()
+
+	for peer := range pm.runningPeers {
+		if peer.inbound {
+			inbound++
+		} else {
+			outbound++
+		}
+	}
+	return inbound, outbound
 }
 
+// Return if more outbound peers are needed
 func (pm *PeerManager) NeedMorePeers() bool {
-	return pm.PeersCount() < MinConnCount
+	minCount := MinConnCount
+	if minCount > pm.maxOutbound {
+		minCount = pm.maxOutbound
+	}
+
+	var outbound int
+	for _, peer := range pm.ConnectedPeers() {
+		if !peer.inbound {
+			outbound++
+		}
+	}
+	return outbound < minCount
 }
 
 func (pm *PeerManager) ConnectPeer(addr string) {
-	pm.connManager.Connect(addr)
+	pm.connManager.Connect(normalizeAddr(addr))
 }
 
 func (pm *PeerManager) AddConnectedPeer(peer *Peer) {
 	log.Trace("PeerManager add connected peer:", peer)
 	// Add peer to list
 	pm.Peers.AddPeer(peer)
+	peer.established = true
 
-	addr := peer.Addr().String()
+	addr := peer.AddrString()
 
 	// Remove addr from connecting list
+	pm.connManager.Lock()
 	pm.connManager.removeAddrFromConnectingList(addr)
+	pm.connManager.Unlock()
 
 	// Mark addr as connected
 	pm.addrManager.AddAddr(addr)
+
+	pm.notifyEvent(EventPeerConnected, peer, addr, "")
 }
 
 func (pm *PeerManager) DisconnectPeer(peer *Peer) {
+	pm.DisconnectPeerWithReason(peer, "disconnected by local peer")
+}
+
+// Disconnect the peer, the reason is reported by the disconnected event
+func (pm *PeerManager) DisconnectPeerWithReason(peer *Peer, reason string) {
 	if peer == nil {
 		return
 	}
-	log.Trace("PeerManager disconnect peer:", peer.String())
-	peer, ok := pm.RemovePeer(peer.ID())
+	log.Trace("PeerManager disconnect peer:", peer.String(), ", reason: ", reason)
+	peer.disconnectWith(reason)
+	removed, ok := pm.RemovePeer(peer.ID())
 	if ok {
-		addr := peer.Addr().String()
-		peer.Disconnect()
+		addr := removed.AddrString()
+		removed.disconnectWith(reason)
+		pm.connManager.Lock()
 		pm.connManager.removeAddrFromConnectingList(addr)
+		pm.connManager.Unlock()
 		pm.addrManager.DisconnectedAddr(addr)
 	}
 }
 
-func (pm *PeerManager) OnDiscardAddr(addr string) {
+func (pm *PeerManager) OnConnectFailed(addr string) {
+	pm.addrManager.FailedAddr(addr)
+}
+
+func (pm *PeerManager) OnDemoteAddr(addr string) {
+	pm.addrManager.DemoteAddr(addr)
+	pm.notifyEvent(EventAddrDemoted, nil, addr, "too many failed connection attempts")
+}
+
+func (pm *PeerManager) discardAddr(addr, reason string) {
 	pm.addrManager.DiscardAddr(addr)
+	pm.notifyEvent(EventAddrDiscarded, nil, addr, reason)
 }
 
+func (pm *PeerManager) AddrManager() *AddrManager {
+	return pm.addrManager
+}
+
+// Return at most MaxAddrsReply random addresses sampled from the address book
 func (pm *PeerManager) RandAddrs() []Addr {
-	peers := pm.ConnectedPeers()
+	knownAddrs := pm.addrManager.SampleAddrs(MaxAddrsReply)
 
-	log.Info("Rand peer addrs, connected peers:", peers)
-	count := len(peers)
-	if count > MaxOutboundCount {
-		count = MaxOutboundCount
+	addrs := make([]Addr, 0, len(knownAddrs))
+	for _, ka := range knownAddrs {
+		ip16, port, ok := splitAddr(ka.Addr)
+		if !ok {
+			continue
+		}
+		addrs = append(addrs, Addr{
+			Time:     ka.LastSeen.UnixNano(),
+			Services: ka.Services,
+			IP:       ip16,
+			Port:     port,
+		})
 	}
 
-	addrs := make([]Addr, count)
-	for count > 0 {
-		count--
-		addrs = append(addrs, *peers[count].Addr())
+	return addrs
+}
+
+// Return the addresses of the outbound peers connected or connecting
+func (pm *PeerManager) outboundAddrs() []string {
+	pm.runningLock.Lock()
+	addrs := make([]string, 0, len(pm.runningPeers))
+	for peer := range pm.runningPeers {
+		if !peer.inbound {
+			addrs = append(addrs, peer.AddrString())
+		}
 	}
+	pm.runningLock.Unlock()
 
+	// Peers in handshake are in both running peers and the connecting list
+	for _, addr := range pm.connManager.ConnectingAddrs() {
+		if !containsAddr(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
 	return addrs
 }
 
-func (pm *PeerManager) connectPeers() {
-	if pm.NeedMorePeers() {
-		addrs := pm.addrManager.GetIdleAddrs(MaxOutboundCount)
-		for _, addr := range addrs {
-			go pm.ConnectPeer(addr)
+func containsAddr(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
 		}
 	}
+	return false
+}
+
+func (pm *PeerManager) connectPeers() {
+	if pm.ConnectOnly() {
+		pm.keepConnectPeers()
+		return
+	}
+
+	if !pm.NeedMorePeers() {
+		return
+	}
+
+	_, outbound := pm.ConnCount()
+	count := pm.maxOutbound - outbound - pm.connManager.PendingCount()
+	if count <= 0 {
+		return
+	}
+
+	addrs := pm.addrManager.GetIdleAddrs(count, pm.outboundAddrs())
+	if len(addrs) < count {
+		pm.queryDNSSeeds()
+	}
+	for _, addr := range addrs {
+		pm.ConnectPeer(addr)
+	}
 }
 
 func (pm *PeerManager) keepConnections() {
+	defer pm.wg.Done()
+
 	pm.connectPeers()
 
 	ticker := time.NewTicker(time.Second * InfoUpdateDuration)
 	defer ticker.Stop()
-	for range ticker.C {
-		pm.connectPeers()
+	for {
+		select {
+		case <-ticker.C:
+			pm.connectPeers()
+		case <-pm.quit:
+			return
+		}
+	}
+}
+
+// Write the changes of the address book to the file every AddrsFlushInterval
+func (pm *PeerManager) flushAddrs() {
+	defer pm.wg.Done()
+
+	ticker := time.NewTicker(AddrsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pm.addrManager.flush()
+		case <-pm.quit:
+			return
+		}
 	}
 }
 
 func (pm *PeerManager) listenConnection() {
-	listener, err := net.Listen("tcp", fmt.Sprint(":", pm.Local().Port()))
-	if err != nil {
-		fmt.Println("Start peer listening err, ", err.Error())
-		return
+	defer pm.wg.Done()
+
+	pm.runningLock.Lock()
+	listener := pm.listener
+	pm.runningLock.Unlock()
+
+	if listener == nil {
+		var err error
+		port := strconv.Itoa(int(pm.Local().Port()))
+		listener, err = net.Listen(pm.ipPreference.network(), net.JoinHostPort("", port))
+		if err != nil {
+			fmt.Println("Start peer listening err, ", err.Error())
+			return
+		}
 	}
 	defer listener.Close()
 
+	pm.runningLock.Lock()
+	select {
+	case <-pm.quit:
+		pm.runningLock.Unlock()
+		return
+	default:
+		pm.listener = listener
+	}
+	pm.runningLock.Unlock()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			select {
+			case <-pm.quit:
+				return
+			default:
+			}
 			fmt.Println("Error accepting ", err.Error())
 			continue
 		}
 		fmt.Printf("New peer connection accepted, remote: %s local: %s\n", conn.RemoteAddr(), conn.LocalAddr())
 
-		peer := NewPeer(conn)
-		go peer.Read()
+		// Refuse all inbound peers in connect only mode
+		if pm.ConnectOnly() {
+			log.Info("Refuse inbound connection in connect only mode, remote:", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		// Refuse banned peer
+		if pm.banList.IsBanned(conn.RemoteAddr().String()) {
+			log.Info("Refuse banned peer connection, remote:", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		peer := NewPeer(pm, conn, true)
+		pm.runPeer(peer)
 	}
 }
 
@@ -203,8 +636,8 @@ func (pm *PeerManager) OnVersion(peer *Peer, v *Version) error {
 	// Check if handshake with itself
 	if v.Nonce == pm.Local().ID() {
 		log.Error("SPV disconnect peer, peer handshake with itself")
-		pm.DisconnectPeer(peer)
-		pm.OnDiscardAddr(peer.Addr().String())
+		pm.DisconnectPeerWithReason(peer, "handshake with itself")
+		pm.discardAddr(peer.AddrString(), "handshake with itself")
 		return errors.New("Peer handshake with itself")
 	}
 
@@ -217,7 +650,7 @@ func (pm *PeerManager) OnVersion(peer *Peer, v *Version) error {
 	knownPeer, ok := pm.RemovePeer(v.Nonce)
 	if ok {
 		log.Trace("Reconnect peer ", v.Nonce)
-		knownPeer.Disconnect()
+		knownPeer.disconnectWith("replaced by a new connection")
 	}
 
 	log.Info("Is known peer:", ok)
@@ -227,7 +660,7 @@ func (pm *PeerManager) OnVersion(peer *Peer, v *Version) error {
 
 	// Handle peer handshake
 	if err := pm.msgHandler.OnHandshake(v); err != nil {
-		pm.DisconnectPeer(peer)
+		pm.DisconnectPeerWithReason(peer, err.Error())
 		return err
 	}
 
@@ -240,7 +673,7 @@ func (pm *PeerManager) OnVersion(peer *Peer, v *Version) error {
 		message = new(VerAck)
 	}
 
-	go peer.Send(message)
+	peer.Send(message)
 
 	return nil
 }
@@ -251,7 +684,7 @@ func (pm *PeerManager) OnVerAck(peer *Peer, va *VerAck) error {
 	}
 
 	if peer.State() == HANDSHAKE {
-		go peer.Send(va)
+		peer.Send(va)
 	}
 
 	peer.SetState(ESTABLISH)
@@ -262,30 +695,49 @@ func (pm *PeerManager) OnVerAck(peer *Peer, va *VerAck) error {
 	// Notify peer connected
 	pm.msgHandler.OnPeerEstablish(peer)
 
-	if pm.NeedMorePeers() {
-		go peer.Send(new(AddrsReq))
+	if !pm.ConnectOnly() && pm.NeedMorePeers() {
+		peer.Send(new(AddrsReq))
 	}
 
 	return nil
 }
 
+// Save the received addresses into the address book, they will be
+// connected later by keepConnections when more peers are needed.
 func (pm *PeerManager) OnAddrs(peer *Peer, addrs *Addrs) error {
-	for _, addr := range addrs.Addrs {
+	// Gossiped addresses are never connected in connect only mode
+	if pm.ConnectOnly() {
+		log.Debug("Ignore addresses from peer ", peer.ID(), " in connect only mode")
+		return nil
+	}
+
+	list := addrs.Addrs
+	if len(list) > MaxAddrsPerMsg {
+		log.Warnf("Peer %d sent %d addresses, only %d accepted", peer.ID(), len(list), MaxAddrsPerMsg)
+		list = list[:MaxAddrsPerMsg]
+	}
+
+	source := peer.AddrString()
+	for _, addr := range list {
 		// Skip local peer
 		if addr.ID == pm.Local().ID() {
 			continue
 		}
-		// Skip peer already connected
-		if pm.EstablishedPeer(addr.ID) {
-			continue
-		}
 		// Skip invalid port
 		if addr.Port == 0 {
 			continue
 		}
-		// Handle new address
-		if pm.NeedMorePeers() {
-			pm.ConnectPeer(addr.String())
+		// Skip private and reserved addresses
+		if !isRoutable(addr.IP[:]) {
+			continue
+		}
+		// Save to address book, ELA nodes set the address time in nanoseconds
+		var seen time.Time
+		if addr.Time > 0 {
+			seen = time.Unix(0, addr.Time)
+		}
+		if pm.addrManager.NewAddr(addrString(&addr), source, addr.Services, seen) {
+			pm.notifyEvent(EventAddrLearned, nil, addrString(&addr), source)
 		}
 	}
 
@@ -293,7 +745,14 @@ func (pm *PeerManager) OnAddrs(peer *Peer, addrs *Addrs) error {
 }
 
 func (pm *PeerManager) OnAddrsReq(peer *Peer, req *AddrsReq) error {
+	// Limit the rate of getaddr of the peer
+	if !peer.lastAddrsReq.IsZero() && time.Since(peer.lastAddrsReq) < AddrsReqInterval {
+		log.Debug("Ignore frequent getaddr from peer ", peer.ID())
+		return nil
+	}
+	peer.lastAddrsReq = time.Now()
+
 	addrs := pm.RandAddrs()
-	go peer.Send(NewAddrs(addrs))
+	peer.Send(NewAddrs(addrs))
 	return nil
 }
@@ -0,0 +1,105 @@
+package net
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p"
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p/msg"
+)
+
+// Create a peer manager not started and a peer of it
+func newTestPeerManager(t *testing.T) (*PeerManager, *Peer, func()) {
+	dataDir, err := ioutil.TempDir("", "peermanager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := new(Peer)
+	local.SetID(100)
+	pm := NewPeerManager(local, &Config{DisableListen: true, DataDir: dataDir})
+
+	conn, remote := net.Pipe()
+	peer := NewPeer(pm, conn, false)
+	peer.SetID(1)
+	return pm, peer, func() {
+		conn.Close()
+		remote.Close()
+		os.RemoveAll(dataDir)
+	}
+}
+
+// Return an address of the public network 1.0.0.0/8 by the index
+func testPublicAddr(i int) Addr {
+	ip16, port, _ := splitAddr(net.JoinHostPort(net.IPv4(1, byte(i>>16), byte(i>>8), byte(i)).String(), "20866"))
+	return Addr{Time: time.Now().UnixNano(), IP: ip16, Port: port}
+}
+
+func TestOnAddrs(t *testing.T) {
+	pm, peer, cleanup := newTestPeerManager(t)
+	defer cleanup()
+
+	// Private, loopback and reserved addresses are dropped
+	var addrs []Addr
+	for _, host := range []string{"10.0.0.1", "192.168.1.1", "127.0.0.1", "::1", "fe80::1", "0.0.0.0"} {
+		ip16, port, _ := splitAddr(net.JoinHostPort(host, "20866"))
+		addrs = append(addrs, Addr{IP: ip16, Port: port})
+	}
+	// So are the addresses without a port
+	noPort := testPublicAddr(0)
+	noPort.Port = 0
+	addrs = append(addrs, noPort)
+	pm.OnAddrs(peer, NewAddrs(addrs))
+	if known := pm.addrManager.KnownAddrs(); len(known) != 0 {
+		t.Fatalf("%d addresses learned, expect none", len(known))
+	}
+
+	// Addresses over MaxAddrsPerMsg are ignored
+	addrs = nil
+	for i := 1; i <= MaxAddrsPerMsg+500; i++ {
+		addrs = append(addrs, testPublicAddr(i))
+	}
+	pm.OnAddrs(peer, NewAddrs(addrs))
+	if known := pm.addrManager.KnownAddrs(); len(known) != MaxAddrsPerMsg {
+		t.Errorf("%d addresses learned, expect %d", len(known), MaxAddrsPerMsg)
+	}
+}
+
+func TestOnAddrsReq(t *testing.T) {
+	pm, peer, cleanup := newTestPeerManager(t)
+	defer cleanup()
+
+	addrs := make([]Addr, 0, MaxAddrsReply*2)
+	for i := 1; i <= MaxAddrsReply*2; i++ {
+		addrs = append(addrs, testPublicAddr(i))
+	}
+	pm.OnAddrs(peer, NewAddrs(addrs))
+
+	// The reply is capped at MaxAddrsReply
+	pm.OnAddrsReq(peer, new(AddrsReq))
+	if len(peer.sendQueue) != 1 {
+		t.Fatalf("%d messages replied, expect 1", len(peer.sendQueue))
+	}
+	reply, ok := (<-peer.sendQueue).(*Addrs)
+	if !ok {
+		t.Fatal("getaddr not replied with addr")
+	}
+	if len(reply.Addrs) != MaxAddrsReply {
+		t.Errorf("replied %d addresses, expect %d", len(reply.Addrs), MaxAddrsReply)
+	}
+
+	// A second getaddr inside the interval is ignored
+	pm.OnAddrsReq(peer, new(AddrsReq))
+	if len(peer.sendQueue) != 0 {
+		t.Error("getaddr inside the interval replied")
+	}
+
+	// It is replied again after the interval
+	peer.lastAddrsReq = time.Now().Add(-AddrsReqInterval)
+	pm.OnAddrsReq(peer, new(AddrsReq))
+	if len(peer.sendQueue) != 1 {
+		t.Error("getaddr after the interval not replied")
+	}
+}
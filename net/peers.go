@@ -7,8 +7,9 @@ import (
 )
 
 type Peers struct {
-	syncPeerLock *sync.Mutex
-	syncPeer     *Peer
+	syncPeerLock      *sync.Mutex
+	syncPeer          *Peer
+	onSyncPeerChanged func(peer *Peer, reason string)
 
 	peersLock *sync.RWMutex
 	local     *Peer
@@ -44,15 +45,19 @@ func (p *Peers) Exist(peer *Peer) bool {
 }
 
 func (p *Peers) RemovePeer(id uint64) (*Peer, bool) {
-	p.peersLock.Lock()
-	defer p.peersLock.Unlock()
-
-	if p.syncPeer != nil && id == p.syncPeer.ID() {
-		p.syncPeer = nil
-	}
+	// Take syncPeerLock before peersLock, the same order as GetSyncPeer
+	p.syncPeerLock.Lock()
+	changed := p.syncPeer != nil && id == p.syncPeer.ID() && p.setSyncPeer(nil)
 
+	p.peersLock.Lock()
 	peer, ok := p.peers[id]
 	delete(p.peers, id)
+	p.peersLock.Unlock()
+	p.syncPeerLock.Unlock()
+
+	if changed {
+		p.syncPeerChanged(nil, "sync peer removed")
+	}
 
 	return peer, ok
 }
@@ -91,29 +96,22 @@ func (p *Peers) GetBestPeer() *Peer {
 	p.peersLock.RLock()
 	defer p.peersLock.RUnlock()
 
-	return p.getBestPeer()
+	bestPeer, _ := p.getBestPeer()
+	return bestPeer
 }
 
-func (p *Peers) getBestPeer() *Peer {
+// Return the established peer with the highest score, see PeerScore
+func (p *Peers) getBestPeer() (*Peer, PeerScore) {
 	var bestPeer *Peer
-	for _, peer := range p.peers {
-
-		// Skip unestablished peer
-		if peer.State() != ESTABLISH {
-			continue
-		}
-
-		if bestPeer == nil {
-			bestPeer = peer
-			continue
-		}
-
-		if peer.height > bestPeer.height {
-			bestPeer = peer
+	var bestScore PeerScore
+	for peer, score := range p.scores() {
+		if bestPeer == nil || score.Score > bestScore.Score ||
+			score.Score == bestScore.Score && score.Height > bestScore.Height {
+			bestPeer, bestScore = peer, score
 		}
 	}
 
-	return bestPeer
+	return bestPeer, bestScore
 }
 
 func (p *Peers) Broadcast(msg Message) {
@@ -132,26 +130,56 @@ func (p *Peers) Broadcast(msg Message) {
 			continue
 		}
 
-		go peer.Send(msg)
+		peer.Send(msg)
 	}
 }
 
 func (p *Peers) SetSyncPeer(peer *Peer) {
 	p.syncPeerLock.Lock()
-	defer p.syncPeerLock.Unlock()
+	changed := p.setSyncPeer(peer)
+	p.syncPeerLock.Unlock()
 
-	p.syncPeer = peer
+	if changed {
+		p.syncPeerChanged(peer, "")
+	}
 }
 
 func (p *Peers) GetSyncPeer() *Peer {
 	p.syncPeerLock.Lock()
-	defer p.syncPeerLock.Unlock()
-
+	var reason string
+	var changed bool
 	if p.syncPeer == nil {
-		p.syncPeer = p.getBestPeer()
+		p.peersLock.RLock()
+		bestPeer, score := p.getBestPeer()
+		p.peersLock.RUnlock()
+		if bestPeer != nil {
+			reason, changed = score.String(), p.setSyncPeer(bestPeer)
+		}
+	}
+	syncPeer := p.syncPeer
+	p.syncPeerLock.Unlock()
+
+	if changed {
+		p.syncPeerChanged(syncPeer, reason)
 	}
 
-	return p.syncPeer
+	return syncPeer
+}
+
+// Set the sync peer and return if it is changed, must be called with syncPeerLock held.
+// The caller fires syncPeerChanged after the locks are released.
+func (p *Peers) setSyncPeer(peer *Peer) bool {
+	if p.syncPeer == peer {
+		return false
+	}
+	p.syncPeer = peer
+	return true
+}
+
+func (p *Peers) syncPeerChanged(peer *Peer, reason string) {
+	if p.onSyncPeerChanged != nil {
+		p.onSyncPeerChanged(peer, reason)
+	}
 }
 
 func (p *Peers) IsSyncPeer(peer *Peer) bool {
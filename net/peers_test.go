@@ -0,0 +1,51 @@
+package net
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRemoveSyncPeer(t *testing.T) {
+	peers := newPeers(new(Peer))
+	changed := make(chan *Peer, 200)
+	peers.onSyncPeerChanged = func(peer *Peer, reason string) {
+		// The callback can use the peers without a deadlock
+		peers.IsSyncPeer(peer)
+		changed <- peer
+	}
+
+	var wg sync.WaitGroup
+	for i := uint64(1); i <= 50; i++ {
+		peer := &Peer{id: i}
+		peers.AddPeer(peer)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			peers.SetSyncPeer(peer)
+		}()
+		go func() {
+			defer wg.Done()
+			peers.RemovePeer(peer.ID())
+		}()
+	}
+	wg.Wait()
+
+	if count := peers.PeersCount(); count != 0 {
+		t.Errorf("%d peers left after removed", count)
+	}
+
+	// Removing the sync peer clears it and notifies the change
+	peer := &Peer{id: 100}
+	peers.AddPeer(peer)
+	peers.SetSyncPeer(peer)
+	for len(changed) > 0 {
+		<-changed
+	}
+	peers.RemovePeer(peer.ID())
+	if len(changed) != 1 || <-changed != nil {
+		t.Error("sync peer removed without notified")
+	}
+	if peers.IsSyncPeer(peer) {
+		t.Error("removed peer is still the sync peer")
+	}
+}
@@ -0,0 +1,208 @@
+package net
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	. "github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+/*
+The sync peer is the established peer with the highest score. The score is
+the product of the factors below, each in range (0, 1], so a peer is chosen
+by how well it served us and not only by the height it reports.
+
+	latency   1 / (1 + RTT in seconds), DefaultPeerRTT is used before measured
+	delivery  (delivered + 1) / (requested + 1) of the data requests
+	timeouts  0.8 to the power of request timeouts
+	failures  0.5 to the power of times it failed as the sync peer
+	height    1 at the best height, 0.25 if below it, 0.1 if a majority of the
+	          established peers are more than MaxHeightAhead blocks below
+	          it, for the height is too far ahead to be believed
+
+The best height is the highest height of the established peers not too far
+ahead, it is the height the chain is synced to.
+*/
+
+const (
+	// The RTT of a peer not measured yet
+	DefaultPeerRTT = time.Second
+	// How many blocks a peer can report ahead of the majority of peers without penalty
+	MaxHeightAhead = 6
+	// Max count of timeouts or failures taken into the score
+	maxScorePenalties = 10
+)
+
+// PeerScore is how a peer is scored to be chosen as the sync peer
+type PeerScore struct {
+	// The overall score, higher is better
+	Score float64
+	// The factors of the score
+	RTT          time.Duration
+	Requested    uint32
+	Delivered    uint32
+	Timeouts     uint32
+	SyncFailures uint32
+	Height       uint64
+	BestHeight   uint64
+}
+
+func (s PeerScore) String() string {
+	return fmt.Sprintf("score %.4f, RTT %s, delivered %d/%d, timeouts %d, sync failures %d, height %d, best height %d",
+		s.Score, s.RTT, s.Delivered, s.Requested, s.Timeouts, s.SyncFailures, s.Height, s.BestHeight)
+}
+
+// Count a data request sent to the peer
+func (peer *Peer) OnRequest() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	peer.requested++
+}
+
+// Count a requested data delivered by the peer
+func (peer *Peer) OnDelivered() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	peer.delivered++
+}
+
+// Count a data request to the peer timed out
+func (peer *Peer) OnRequestTimeout() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	peer.timeouts++
+}
+
+// Count a failure of the peer as the sync peer, like stalling or sending data can not be committed
+func (peer *Peer) OnSyncFailed() {
+	peer.statsLock.Lock()
+	defer peer.statsLock.Unlock()
+	peer.syncFailures++
+}
+
+// Return how many times the peer failed as the sync peer
+func (peer *Peer) SyncFailures() uint32 {
+	peer.statsLock.RLock()
+	defer peer.statsLock.RUnlock()
+	return peer.syncFailures
+}
+
+// Score the peer against the best height, tooFarAhead tells if the height of the peer is not believed
+func (peer *Peer) score(bestHeight uint64, tooFarAhead bool) PeerScore {
+	peer.statsLock.RLock()
+	s := PeerScore{
+		RTT:          peer.rtt,
+		Requested:    peer.requested,
+		Delivered:    peer.delivered,
+		Timeouts:     peer.timeouts,
+		SyncFailures: peer.syncFailures,
+		Height:       peer.Height(),
+		BestHeight:   bestHeight,
+	}
+	peer.statsLock.RUnlock()
+
+	rtt := s.RTT
+	if rtt == 0 {
+		rtt = DefaultPeerRTT
+	}
+	s.Score = 1 / (1 + rtt.Seconds())
+
+	delivery := float64(s.Delivered+1) / float64(s.Requested+1)
+	if delivery > 1 {
+		delivery = 1
+	}
+	s.Score *= delivery
+
+	s.Score *= math.Pow(0.8, float64(minUint32(s.Timeouts, maxScorePenalties)))
+	s.Score *= math.Pow(0.5, float64(minUint32(s.SyncFailures, maxScorePenalties)))
+
+	switch {
+	case tooFarAhead:
+		s.Score *= 0.1
+	case s.Height < bestHeight:
+		s.Score *= 0.25
+	}
+
+	return s
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Return the scores of the established peers by peer ID
+func (p *Peers) PeerScores() map[uint64]PeerScore {
+	p.peersLock.RLock()
+	defer p.peersLock.RUnlock()
+
+	scores := make(map[uint64]PeerScore)
+	for peer, score := range p.scores() {
+		scores[peer.ID()] = score
+	}
+	return scores
+}
+
+// Return the best height of the established peers, see PeerScore
+func (p *Peers) BestHeight() uint64 {
+	p.peersLock.RLock()
+	defer p.peersLock.RUnlock()
+
+	height, _ := bestHeight(p.establishedPeers())
+	return height
+}
+
+func (p *Peers) establishedPeers() []*Peer {
+	var peers []*Peer
+	for _, peer := range p.peers {
+		// Skip unestablished peer
+		if peer.State() != ESTABLISH {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Return the highest height of the peers not too far ahead, and the peers too far ahead.
+// A height is too far ahead if a majority of the peers are more than MaxHeightAhead
+// blocks below it, so a minority of peers can not raise it by reporting fake heights,
+// and a peer ahead of a few lagging peers is still believed.
+func bestHeight(peers []*Peer) (uint64, map[*Peer]bool) {
+	heights := make([]uint64, 0, len(peers))
+	for _, peer := range peers {
+		heights = append(heights, peer.Height())
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	var best uint64
+	tooFarAhead := make(map[*Peer]bool)
+	for _, peer := range peers {
+		height := peer.Height()
+		// Count the peers more than MaxHeightAhead blocks below
+		below := sort.Search(len(heights), func(i int) bool { return heights[i]+MaxHeightAhead >= height })
+		if below*2 > len(heights) {
+			tooFarAhead[peer] = true
+			continue
+		}
+		if height > best {
+			best = height
+		}
+	}
+	return best, tooFarAhead
+}
+
+func (p *Peers) scores() map[*Peer]PeerScore {
+	peers := p.establishedPeers()
+	best, tooFarAhead := bestHeight(peers)
+
+	scores := make(map[*Peer]PeerScore, len(peers))
+	for _, peer := range peers {
+		scores[peer] = peer.score(best, tooFarAhead[peer])
+	}
+	return scores
+}
@@ -0,0 +1,108 @@
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+func newTestPeers(heights ...uint64) (*Peers, []*Peer) {
+	peers := newPeers(new(Peer))
+	var list []*Peer
+	for i, height := range heights {
+		peer := new(Peer)
+		peer.SetID(uint64(i + 1))
+		peer.SetHeight(height)
+		peer.SetState(p2p.ESTABLISH)
+		peer.rtt = time.Millisecond * 100
+		peers.AddPeer(peer)
+		list = append(list, peer)
+	}
+	return peers, list
+}
+
+func TestBestPeerIgnoresFakeHeight(t *testing.T) {
+	peers, list := newTestPeers(1000, 1001, 1000, 9999999)
+
+	best := peers.GetBestPeer()
+	if best == list[3] {
+		t.Fatal("peer reporting a height far above the majority chosen")
+	}
+
+	scores := peers.PeerScores()
+	if scores[list[3].ID()].Score >= scores[list[0].ID()].Score {
+		t.Errorf("fake height scored %s, honest peer scored %s", scores[list[3].ID()], scores[list[0].ID()])
+	}
+}
+
+func TestBestPeerPrefersReliable(t *testing.T) {
+	peers, list := newTestPeers(1000, 1000, 1000)
+
+	// Peer 1 times out, peer 2 is slow, peer 3 delivers
+	for i := 0; i < 10; i++ {
+		list[0].OnRequest()
+		list[0].OnRequestTimeout()
+		list[2].OnRequest()
+		list[2].OnDelivered()
+	}
+	list[1].rtt = time.Second * 5
+
+	if best := peers.GetBestPeer(); best != list[2] {
+		t.Errorf("peer %d chosen, expect peer %d", best.ID(), list[2].ID())
+	}
+
+	// The sync peer falls behind after keep failing
+	for i := 0; i < 3; i++ {
+		list[2].OnSyncFailed()
+	}
+	if best := peers.GetBestPeer(); best == list[2] {
+		t.Error("peer failed as sync peer still chosen")
+	}
+}
+
+func TestBestPeerBehindMedian(t *testing.T) {
+	peers, list := newTestPeers(900, 1000, 1000)
+
+	// A faster peer behind the best height is not chosen
+	list[0].rtt = time.Millisecond
+	if best := peers.GetBestPeer(); best.Height() != 1000 {
+		t.Errorf("peer at height %d chosen, expect height 1000", best.Height())
+	}
+}
+
+func TestBestPeerTwoPeersLagging(t *testing.T) {
+	peers, list := newTestPeers(1000, 990)
+
+	// The lagging peer is faster, but the one ahead is chosen and the best height is it's height
+	list[1].rtt = time.Millisecond
+	if best := peers.GetBestPeer(); best != list[0] {
+		t.Errorf("peer at height %d chosen, expect height 1000", best.Height())
+	}
+	if height := peers.BestHeight(); height != 1000 {
+		t.Errorf("best height %d, expect 1000", height)
+	}
+}
+
+func TestBestHeight(t *testing.T) {
+	tests := []struct {
+		heights []uint64
+		best    uint64
+	}{
+		{nil, 0},
+		{[]uint64{1000}, 1000},
+		// The peer ahead is believed unless a majority of peers disagree
+		{[]uint64{1000, 1100}, 1100},
+		{[]uint64{1000, 1000, 1100}, 1000},
+		{[]uint64{1000, 1100, 1100}, 1100},
+		{[]uint64{1000, 1000, 1100, 1100}, 1100},
+		{[]uint64{1000, 1001, 1000, 9999999}, 1001},
+		{[]uint64{1000, 1000 + MaxHeightAhead, 1000}, 1000 + MaxHeightAhead},
+	}
+	for _, test := range tests {
+		peers, _ := newTestPeers(test.heights...)
+		if height := peers.BestHeight(); height != test.best {
+			t.Errorf("best height of %v is %d, expect %d", test.heights, height, test.best)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package net
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+var errPipeListenerClosed = errors.New("pipe listener closed")
+
+// PipeNetwork is an in-memory network made of net.Pipe connections,
+// use it as the Dialer and Listener of peer managers for deterministic tests.
+type PipeNetwork struct {
+	sync.Mutex
+	listeners map[string]*PipeListener
+	nextPort  int
+}
+
+func NewPipeNetwork() *PipeNetwork {
+	return &PipeNetwork{
+		listeners: make(map[string]*PipeListener),
+		nextPort:  40000,
+	}
+}
+
+// Listen on the address in ip:port format
+func (n *PipeNetwork) Listen(addr string) (*PipeListener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	defer n.Unlock()
+
+	if _, ok := n.listeners[tcpAddr.String()]; ok {
+		return nil, errors.New("pipe address already in use " + addr)
+	}
+
+	listener := &PipeListener{
+		network: n,
+		addr:    tcpAddr,
+		conns:   make(chan net.Conn),
+		quit:    make(chan struct{}),
+	}
+	n.listeners[tcpAddr.String()] = listener
+	return listener, nil
+}
+
+// Dial the address of a PipeListener in this network, host names are resolved like Listen
+func (n *PipeNetwork) Dial(addr string) (net.Conn, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	n.Lock()
+	listener, ok := n.listeners[tcpAddr.String()]
+	n.nextPort++
+	localAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: n.nextPort}
+	n.Unlock()
+
+	if !ok {
+		return nil, errors.New("pipe connection refused " + addr)
+	}
+
+	local, remote := net.Pipe()
+	select {
+	case listener.conns <- &pipeConn{Conn: remote, local: listener.addr, remote: localAddr}:
+	case <-listener.quit:
+		return nil, errors.New("pipe connection refused " + addr)
+	}
+
+	return &pipeConn{Conn: local, local: localAddr, remote: listener.addr}, nil
+}
+
+// PipeListener accepts connections dialed through it's PipeNetwork
+type PipeListener struct {
+	network *PipeNetwork
+	addr    *net.TCPAddr
+	conns   chan net.Conn
+	quit    chan struct{}
+	once    sync.Once
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.quit:
+		return nil, errPipeListenerClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.once.Do(func() {
+		close(l.quit)
+		l.network.Lock()
+		delete(l.network.listeners, l.addr.String())
+		l.network.Unlock()
+	})
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+// A net.Pipe connection with TCP style addresses
+type pipeConn struct {
+	net.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+func (c *pipeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
@@ -0,0 +1,70 @@
+package net
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPipeNetwork(t *testing.T) {
+	network := NewPipeNetwork()
+	listener, err := network.Listen("127.0.0.1:20866")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.Listen("127.0.0.1:20866"); err == nil {
+		t.Error("listen on an address in use succeeded")
+	}
+
+	accepted := make(chan interface{}, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			accepted <- err
+			return
+		}
+		accepted <- conn
+	}()
+
+	conn, err := network.Dial("127.0.0.1:20866")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var remote *pipeConn
+	switch result := (<-accepted).(type) {
+	case error:
+		t.Fatal(result)
+	case *pipeConn:
+		remote = result
+	}
+	defer remote.Close()
+
+	// Both ends see each other's TCP style address
+	if addr := conn.RemoteAddr().String(); addr != "127.0.0.1:20866" {
+		t.Errorf("dialed remote address %s", addr)
+	}
+	if conn.LocalAddr().String() != remote.RemoteAddr().String() {
+		t.Errorf("dialed local address %s, accepted remote address %s",
+			conn.LocalAddr(), remote.RemoteAddr())
+	}
+
+	go conn.Write([]byte("ping"))
+	data := make([]byte, 4)
+	if _, err := io.ReadFull(remote, data); err != nil || string(data) != "ping" {
+		t.Errorf("read %q error %v", data, err)
+	}
+
+	if _, err := network.Dial("127.0.0.1:20867"); err == nil {
+		t.Error("dial an address not listened succeeded")
+	}
+
+	// Closed listener refuses connections and stops accepting
+	listener.Close()
+	if _, err := listener.Accept(); err != errPipeListenerClosed {
+		t.Errorf("accept on closed listener returned %v", err)
+	}
+	if _, err := network.Dial("127.0.0.1:20866"); err == nil {
+		t.Error("dial a closed listener succeeded")
+	}
+}
@@ -0,0 +1,109 @@
+package net
+
+import (
+	"time"
+)
+
+// RateLimit limits how many messages of a command a peer can send us, with
+// a token bucket refilled by Rate tokens per second and holding at most Burst
+// tokens. Each message takes a token, messages without a token are dropped
+// before decoded and add OffenseMessageFlood to the ban score of the peer.
+type RateLimit struct {
+	// Messages per second in average, the limit is disabled if it is not positive
+	Rate float64
+	// Max messages in a burst
+	Burst int
+}
+
+// The limit of commands not listed in DefaultRateLimits, they share one token
+// bucket as if they were all UnknownCommand. Set the limit of UnknownCommand
+// in Config.MessageRateLimits to override it.
+var DefaultRateLimit = RateLimit{Rate: 10, Burst: 100}
+
+// The default limits by command, override them with Config.MessageRateLimits.
+// The data we request like blocks and transactions come in bursts when syncing,
+// so they are limited loosely, the data peers push to us are limited tightly.
+var DefaultRateLimits = map[string]RateLimit{
+	"version":     {Rate: 0.1, Burst: 2},
+	"verack":      {Rate: 0.1, Burst: 2},
+	"getaddr":     {Rate: 0.1, Burst: 5},
+	"addr":        {Rate: 0.1, Burst: 10},
+	"ping":        {Rate: 1, Burst: 10},
+	"pong":        {Rate: 1, Burst: 10},
+	"filterload":  {Rate: 1, Burst: 10},
+	"inv":         {Rate: 10, Burst: 100},
+	"getblocks":   {Rate: 10, Burst: 100},
+	"getdata":     {Rate: 500, Burst: 5000},
+	"notfound":    {Rate: 500, Burst: 5000},
+	"merkleblock": {Rate: 500, Burst: 5000},
+	"tx":          {Rate: 500, Burst: 5000},
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// Take a token from the bucket, return false if there is none
+func (b *tokenBucket) take(now time.Time) bool {
+	if b.limit.Rate <= 0 {
+		return true
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter keeps the token buckets of a peer by command,
+// it is only accessed by the read goroutine of the peer.
+type rateLimiter struct {
+	limits  map[string]RateLimit
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(limits map[string]RateLimit) *rateLimiter {
+	return &rateLimiter{limits: limits, buckets: make(map[string]*tokenBucket)}
+}
+
+// Return if a message of the command is allowed now
+func (l *rateLimiter) allow(cmd string, now time.Time) bool {
+	limit, ok := l.limits[cmd]
+	if !ok {
+		// Commands not listed take tokens from one bucket, so rotating them
+		// does not get around the limit or grow the buckets
+		cmd = UnknownCommand
+		limit, ok = l.limits[cmd]
+		if !ok {
+			limit = DefaultRateLimit
+		}
+	}
+
+	bucket, ok := l.buckets[cmd]
+	if !ok {
+		bucket = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[cmd] = bucket
+	}
+	return bucket.take(now)
+}
+
+// Merge the limits by command into the default limits
+func mergeRateLimits(limits map[string]RateLimit) map[string]RateLimit {
+	merged := make(map[string]RateLimit, len(DefaultRateLimits)+len(limits))
+	for cmd, limit := range DefaultRateLimits {
+		merged[cmd] = limit
+	}
+	for cmd, limit := range limits {
+		merged[cmd] = limit
+	}
+	return merged
+}
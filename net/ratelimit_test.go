@@ -0,0 +1,70 @@
+package net
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(mergeRateLimits(map[string]RateLimit{
+		"inv": {Rate: 2, Burst: 5},
+		"tx":  {Rate: 0},
+	}))
+	now := time.Now()
+
+	// The burst is allowed at once
+	for i := 0; i < 5; i++ {
+		if !limiter.allow("inv", now) {
+			t.Fatalf("message %d in the burst not allowed", i)
+		}
+	}
+	if limiter.allow("inv", now) {
+		t.Fatal("message over the burst allowed")
+	}
+
+	// Tokens are refilled by the rate
+	now = now.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		if !limiter.allow("inv", now) {
+			t.Fatalf("message %d after refilled not allowed", i)
+		}
+	}
+	if limiter.allow("inv", now) {
+		t.Fatal("message over the refilled tokens allowed")
+	}
+
+	// Tokens never exceed the burst
+	now = now.Add(time.Hour)
+	var allowed int
+	for limiter.allow("inv", now) {
+		allowed++
+	}
+	if allowed != 5 {
+		t.Errorf("allowed %d messages after idle, expect 5", allowed)
+	}
+
+	// Commands are limited separately, and a not positive rate disables the limit
+	for i := 0; i < 10000; i++ {
+		if !limiter.allow("tx", now) {
+			t.Fatal("message of command without limit not allowed")
+		}
+	}
+
+	// Commands not listed share the default limit
+	allowed = 0
+	for i := 0; i < DefaultRateLimit.Burst*2; i++ {
+		if limiter.allow(fmt.Sprint("cmd", i), now) {
+			allowed++
+		}
+	}
+	if allowed != DefaultRateLimit.Burst {
+		t.Errorf("allowed %d messages of distinct unknown commands, expect %d", allowed, DefaultRateLimit.Burst)
+	}
+	if limiter.allow(UnknownCommand, now) {
+		t.Error("unknown command allowed after the shared limit exceeded")
+	}
+	if len(limiter.buckets) != 3 {
+		t.Errorf("%d token buckets, expect 3", len(limiter.buckets))
+	}
+}
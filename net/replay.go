@@ -0,0 +1,116 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+/*
+Replayer feeds the received messages of a capture file into a peer manager
+which has not been started. Messages are handled one by one in the recorded
+order on the calling goroutine, and messages sent to the replayed peers are discarded.
+Replayed peers act like outbound peers, a new peer is created for every
+version message so reconnections in the capture are replayed as well.
+*/
+type Replayer struct {
+	pm       *PeerManager
+	reader   *CaptureReader
+	peers    map[uint64]*Peer
+	nextPort int
+	wg       sync.WaitGroup
+}
+
+// Create a replayer of the capture, it must be captured in the network of this peer manager
+func (pm *PeerManager) NewReplayer(reader *CaptureReader) (*Replayer, error) {
+	if reader.Magic() != pm.magic {
+		return nil, fmt.Errorf("capture magic %d does not match network magic %d", reader.Magic(), pm.magic)
+	}
+	return &Replayer{
+		pm:       pm,
+		reader:   reader,
+		peers:    make(map[uint64]*Peer),
+		nextPort: 1,
+	}, nil
+}
+
+// Read the next record of the capture, io.EOF is returned at the end of the capture
+func (r *Replayer) Read() (*CaptureRecord, error) {
+	return r.reader.Read()
+}
+
+// Handle a received message record like it comes from the peer, sent records are ignored
+func (r *Replayer) Dispatch(record *CaptureRecord) error {
+	if record.Direction != CaptureRecv {
+		return nil
+	}
+
+	msg, err := r.pm.makeMessage(record.Command)
+	if err != nil {
+		return err
+	}
+	err = msg.Deserialize(bytes.NewReader(record.Payload))
+	if err != nil {
+		return fmt.Errorf("deserialize captured message %s failed, %s", record.Command, err)
+	}
+
+	peer, ok := r.peers[record.PeerID]
+	if !ok || msg.CMD() == "version" || peer.State() == p2p.INACTIVITY {
+		peer = r.newPeer()
+		r.peers[record.PeerID] = peer
+	}
+
+	peer.OnMessageDecoded(msg)
+	return nil
+}
+
+// Replay all the records of the capture
+func (r *Replayer) Replay() error {
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = r.Dispatch(record)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Disconnect the replayed peers
+func (r *Replayer) Close() {
+	for _, peer := range r.peers {
+		r.pm.DisconnectPeerWithReason(peer, "replay finished")
+	}
+	r.wg.Wait()
+}
+
+// Create a peer backed by a connection discarding everything written to it
+func (r *Replayer) newPeer() *Peer {
+	local, remote := net.Pipe()
+	go io.Copy(ioutil.Discard, remote)
+
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: r.nextPort}
+	r.nextPort++
+	conn := &pipeConn{Conn: local, local: addr, remote: addr}
+
+	peer := NewPeer(r.pm, conn, false)
+	peer.SetState(p2p.HAND)
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		peer.writeHandler()
+	}()
+	return peer
+}
@@ -0,0 +1,205 @@
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	socks5Version     = 5
+	socks5AuthNone    = 0
+	socks5AuthPass    = 2
+	socks5AuthNoMatch = 0xff
+	socks5CmdConnect  = 1
+	socks5AtypIPv4    = 1
+	socks5AtypDomain  = 3
+	socks5AtypIPv6    = 4
+)
+
+// SOCKS5Dialer connects peers through a SOCKS5 proxy.
+// Host names are sent to the proxy unresolved, so no DNS lookup
+// or direct connection to a peer will be made by this dialer.
+type SOCKS5Dialer struct {
+	// The proxy address in host:port format
+	Proxy string
+	// Optional username and password authentication
+	Username string
+	Password string
+	// Timeout of connecting the proxy and the proxy handshake,
+	// ConnTimeOut seconds is used if not set
+	Timeout time.Duration
+}
+
+// The connection through the proxy reports the dialed address as it's remote
+// address, so the peer is known by it's own address and not the proxy's.
+type socks5Conn struct {
+	net.Conn
+	remote *socks5Addr
+}
+
+func (c *socks5Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// The dialed address in host:port format, the host may be a host name
+type socks5Addr struct {
+	addr string
+}
+
+func (a *socks5Addr) Network() string {
+	return "tcp"
+}
+
+func (a *socks5Addr) String() string {
+	return a.addr
+}
+
+func (d *SOCKS5Dialer) Dial(addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", portStr)
+	}
+
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = time.Second * ConnTimeOut
+	}
+
+	conn, err := net.DialTimeout("tcp", d.Proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	err = d.handshake(conn, host, uint16(port))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &socks5Conn{Conn: conn, remote: &socks5Addr{addr: addr}}, nil
+}
+
+func (d *SOCKS5Dialer) handshake(conn net.Conn, host string, port uint16) error {
+	// Negotiate authentication method
+	method := byte(socks5AuthNone)
+	if len(d.Username) > 0 {
+		method = socks5AuthPass
+	}
+	_, err := conn.Write([]byte{socks5Version, 1, method})
+	if err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return errors.New("socks5 proxy returned unknown version")
+	}
+	if reply[1] == socks5AuthNoMatch || reply[1] != method {
+		return errors.New("socks5 proxy authentication method not accepted")
+	}
+
+	if method == socks5AuthPass {
+		err = d.authenticate(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Send connect request
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AtypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AtypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks5 host name too long")
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	// Read connect response
+	resp := make([]byte, 4)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[0] != socks5Version {
+		return errors.New("socks5 proxy returned unknown version")
+	}
+	if resp[1] != 0 {
+		return fmt.Errorf("socks5 proxy connect failed, reply code %d", resp[1])
+	}
+
+	// Discard the bound address
+	var boundLen int
+	switch resp[3] {
+	case socks5AtypIPv4:
+		boundLen = net.IPv4len
+	case socks5AtypIPv6:
+		boundLen = net.IPv6len
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		_, err = io.ReadFull(conn, length)
+		if err != nil {
+			return err
+		}
+		boundLen = int(length[0])
+	default:
+		return errors.New("socks5 proxy returned unknown address type")
+	}
+	bound := make([]byte, boundLen+2)
+	_, err = io.ReadFull(conn, bound)
+	return err
+}
+
+// Username and password authentication defined in RFC1929
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.Username) > 255 || len(d.Password) > 255 {
+		return errors.New("socks5 username or password too long")
+	}
+
+	req := []byte{1, byte(len(d.Username))}
+	req = append(req, d.Username...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	_, err := conn.Write(req)
+	if err != nil {
+		return err
+	}
+
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.New("socks5 proxy authentication failed")
+	}
+	return nil
+}
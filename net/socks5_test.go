@@ -0,0 +1,215 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// A SOCKS5 proxy serving one connection, it checks the handshake and echoes
+// the data after connected. The connect request is sent to requests.
+type testProxy struct {
+	listener net.Listener
+	username string
+	password string
+	// The reply code of the connect request
+	reply byte
+	// Accept the connection but never answer the handshake
+	silent   bool
+	requests chan []byte
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testProxy{listener: listener, requests: make(chan []byte, 1)}
+}
+
+func (p *testProxy) serve() {
+	conn, err := p.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if p.silent {
+		io.Copy(ioutil.Discard, conn)
+		return
+	}
+
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	method := byte(socks5AuthNone)
+	if len(p.username) > 0 {
+		method = socks5AuthPass
+	}
+	if greeting[2] != method {
+		conn.Write([]byte{socks5Version, socks5AuthNoMatch})
+		return
+	}
+	conn.Write([]byte{socks5Version, method})
+
+	if method == socks5AuthPass {
+		head := make([]byte, 2)
+		io.ReadFull(conn, head)
+		username := make([]byte, head[1])
+		io.ReadFull(conn, username)
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		password := make([]byte, length[0])
+		io.ReadFull(conn, password)
+		if string(username) != p.username || string(password) != p.password {
+			conn.Write([]byte{1, 1})
+			return
+		}
+		conn.Write([]byte{1, 0})
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	var addrLen int
+	switch head[3] {
+	case socks5AtypIPv4:
+		addrLen = net.IPv4len
+	case socks5AtypIPv6:
+		addrLen = net.IPv6len
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		head = append(head, length[0])
+		addrLen = int(length[0])
+	}
+	addr := make([]byte, addrLen+2)
+	io.ReadFull(conn, addr)
+	p.requests <- append(head, addr...)
+
+	conn.Write([]byte{socks5Version, p.reply, 0, socks5AtypIPv4, 127, 0, 0, 1, 0, 80})
+	if p.reply == 0 {
+		io.Copy(conn, conn)
+	}
+}
+
+func TestSOCKS5Dial(t *testing.T) {
+	tests := []struct {
+		addr    string
+		request []byte
+	}{
+		{"1.2.3.4:20866", []byte{5, 1, 0, socks5AtypIPv4, 1, 2, 3, 4, 0x51, 0x82}},
+		{"[2001:db8::1]:20866", append(append([]byte{5, 1, 0, socks5AtypIPv6},
+			net.ParseIP("2001:db8::1")...), 0x51, 0x82)},
+		{"node.elastos.org:20866", append(append([]byte{5, 1, 0, socks5AtypDomain, 16},
+			"node.elastos.org"...), 0x51, 0x82)},
+	}
+
+	for _, test := range tests {
+		proxy := newTestProxy(t)
+		go proxy.serve()
+
+		dialer := &SOCKS5Dialer{Proxy: proxy.listener.Addr().String(), Timeout: time.Second}
+		conn, err := dialer.Dial(test.addr)
+		if err != nil {
+			t.Fatalf("dial %s error %s", test.addr, err)
+		}
+		if request := <-proxy.requests; !bytes.Equal(request, test.request) {
+			t.Errorf("dial %s sent request %v, expect %v", test.addr, request, test.request)
+		}
+
+		// The connection is known by the dialed address, not the proxy address
+		if remote := conn.RemoteAddr().String(); remote != test.addr {
+			t.Errorf("dial %s remote address %s", test.addr, remote)
+		}
+
+		// Data goes through the proxy after connected
+		conn.Write([]byte("ping"))
+		echo := make([]byte, 4)
+		if _, err := io.ReadFull(conn, echo); err != nil || string(echo) != "ping" {
+			t.Errorf("dial %s echo %q error %v", test.addr, echo, err)
+		}
+
+		conn.Close()
+		proxy.listener.Close()
+	}
+}
+
+func TestSOCKS5DialAuth(t *testing.T) {
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"secret", true},
+		{"wrong", false},
+	}
+
+	for _, test := range tests {
+		proxy := newTestProxy(t)
+		proxy.username, proxy.password = "user", "secret"
+		go proxy.serve()
+
+		dialer := &SOCKS5Dialer{
+			Proxy:    proxy.listener.Addr().String(),
+			Username: "user",
+			Password: test.password,
+			Timeout:  time.Second,
+		}
+		conn, err := dialer.Dial("1.2.3.4:20866")
+		if test.ok && err != nil {
+			t.Errorf("password %s error %s", test.password, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("password %s accepted", test.password)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		proxy.listener.Close()
+	}
+}
+
+func TestSOCKS5DialConnectFailed(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.reply = 5 // Connection refused
+	go proxy.serve()
+	defer proxy.listener.Close()
+
+	dialer := &SOCKS5Dialer{Proxy: proxy.listener.Addr().String(), Timeout: time.Second}
+	if _, err := dialer.Dial("1.2.3.4:20866"); err == nil {
+		t.Error("dial succeeded while the proxy refused to connect")
+	}
+}
+
+func TestSOCKS5DialTimeout(t *testing.T) {
+	proxy := newTestProxy(t)
+	proxy.silent = true
+	go proxy.serve()
+	defer proxy.listener.Close()
+
+	dialer := &SOCKS5Dialer{Proxy: proxy.listener.Addr().String(), Timeout: time.Millisecond * 100}
+	start := time.Now()
+	if _, err := dialer.Dial("1.2.3.4:20866"); err == nil {
+		t.Fatal("dial succeeded while the proxy never answered")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("dial returned after %s, expect the timeout %s", elapsed, dialer.Timeout)
+	}
+}
+
+func TestPeerAddrThroughProxy(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	conn := &socks5Conn{Conn: local, remote: &socks5Addr{addr: "node.elastos.org:20866"}}
+	peer := NewPeer(&PeerManager{}, conn, false)
+	if addr := peer.AddrString(); addr != "node.elastos.org:20866" {
+		t.Errorf("peer address %s, expect the dialed address", addr)
+	}
+}
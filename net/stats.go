@@ -0,0 +1,144 @@
+package net
+
+import (
+	"sync"
+	"time"
+)
+
+// MsgStats counts the messages and bytes sent and received, bytes include the message header
+type MsgStats struct {
+	MsgsSent  uint64
+	MsgsRecv  uint64
+	BytesSent uint64
+	BytesRecv uint64
+}
+
+// TrafficStats is a snapshot of the traffic of a peer or all peers
+type TrafficStats struct {
+	// The traffic of all commands
+	Total MsgStats
+	// The traffic of each command
+	Commands map[string]MsgStats
+}
+
+// PeerStats is a snapshot of a running peer
+type PeerStats struct {
+	ID        uint64
+	Addr      string
+	Direction string
+	State     string
+	Version   uint32
+	Services  uint64
+	Height    uint64
+	RTT       time.Duration
+	LastSend  time.Time
+	LastRecv  time.Time
+	BanScore  uint32
+	SyncPeer  bool
+	Traffic   TrafficStats
+	// The sync peer score, zero for peers not established
+	Score PeerScore
+}
+
+// NetStats is a snapshot of the peer to peer network
+type NetStats struct {
+	// The traffic of all peers since the peer manager created,
+	// including the peers already disconnected
+	Traffic TrafficStats
+	// The peers connected or in handshake
+	Peers []PeerStats
+}
+
+// Count the traffic by command
+type trafficCounter struct {
+	sync.Mutex
+	total    MsgStats
+	commands map[string]*MsgStats
+}
+
+func (c *trafficCounter) command(cmd string) *MsgStats {
+	if c.commands == nil {
+		c.commands = make(map[string]*MsgStats)
+	}
+	stats, ok := c.commands[cmd]
+	if !ok {
+		stats = new(MsgStats)
+		c.commands[cmd] = stats
+	}
+	return stats
+}
+
+func (c *trafficCounter) sent(cmd string, size int) {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.command(cmd)
+	stats.MsgsSent++
+	stats.BytesSent += uint64(size)
+	c.total.MsgsSent++
+	c.total.BytesSent += uint64(size)
+}
+
+func (c *trafficCounter) recv(cmd string, size int) {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := c.command(cmd)
+	stats.MsgsRecv++
+	stats.BytesRecv += uint64(size)
+	c.total.MsgsRecv++
+	c.total.BytesRecv += uint64(size)
+}
+
+func (c *trafficCounter) snapshot() TrafficStats {
+	c.Lock()
+	defer c.Unlock()
+
+	stats := TrafficStats{
+		Total:    c.total,
+		Commands: make(map[string]MsgStats, len(c.commands)),
+	}
+	for cmd, s := range c.commands {
+		stats.Commands[cmd] = *s
+	}
+	return stats
+}
+
+// Return the traffic statistics of the peer
+func (peer *Peer) Traffic() TrafficStats {
+	return peer.traffic.snapshot()
+}
+
+// Return the traffic statistics of all peers
+func (pm *PeerManager) Traffic() TrafficStats {
+	return pm.traffic.snapshot()
+}
+
+// Return a snapshot of the traffic and the running peers
+func (pm *PeerManager) Stats() NetStats {
+	peers := pm.runningPeerList()
+	scores := pm.PeerScores()
+	stats := NetStats{
+		Traffic: pm.Traffic(),
+		Peers:   make([]PeerStats, 0, len(peers)),
+	}
+	for _, peer := range peers {
+		stats.Peers = append(stats.Peers, PeerStats{
+			ID:        peer.ID(),
+			Addr:      peer.AddrString(),
+			Direction: peer.Direction(),
+			State:     peer.PeerState.String(),
+			Version:   peer.Version(),
+			Services:  peer.Services(),
+			Height:    peer.Height(),
+			RTT:       peer.RTT(),
+			LastSend:  peer.LastSend(),
+			LastRecv:  peer.LastRecv(),
+			BanScore:  pm.BanScore(peer),
+			SyncPeer:  pm.IsSyncPeer(peer),
+			Traffic:   peer.Traffic(),
+			Score:     scores[peer.ID()],
+		})
+	}
+	return stats
+}
@@ -54,6 +54,7 @@ type Blockchain struct {
 	state          ChainState
 	db.DataStore
 	stateListeners []StateListener
+	checkpoints    []Checkpoint
 }
 
 // Create a instance of *Blockchain
@@ -174,6 +175,11 @@ func (bc *Blockchain) CommitBlock(block bloom.MerkleBlock, txs []Transaction) (b
 	header := block.Header
 	commitHeader := &db.StoreHeader{Header: header}
 
+	// Refuse headers conflicting with checkpoints
+	if err := bc.checkCheckpoint(header); err != nil {
+		return false, 0, err
+	}
+
 	// Get current chain tip
 	tip := bc.chainTip()
 	tipHash := tip.Hash()
@@ -199,6 +205,17 @@ func (bc *Blockchain) CommitBlock(block bloom.MerkleBlock, txs []Transaction) (b
 
 	log.Debug("Find parent header height: ", parentHeader.Height)
 
+	// Check the header against the headers it extends, the genesis header is not stored
+	// so the parent of the first header is empty and only the future timestamp is checked
+	if parentHeader.Height > 0 {
+		err = bc.checkHeaderContext(header, parentHeader)
+	} else {
+		err = checkTimestampFuture(header)
+	}
+	if err != nil {
+		return false, 0, err
+	}
+
 	// If this block is already the tip, return
 	if tipHash.IsEqual(header.Hash()) {
 		return false, 0, nil
@@ -221,6 +238,11 @@ func (bc *Blockchain) CommitBlock(block bloom.MerkleBlock, txs []Transaction) (b
 				log.Errorf("error calculating common ancestor: %s", err.Error())
 				return false, 0, err
 			}
+			// Never rollback the blocks below the last checkpoint passed
+			if checkpoint := bc.lastCheckpointHeight(tip.Height); reorgPoint.Height < checkpoint {
+				log.Warnf("Refuse reorganize at block %d below checkpoint %d", reorgPoint.Height, checkpoint)
+				return false, 0, ErrReorgBelowCheckpoint
+			}
 			fmt.Printf("Reorganize At block %d, Wiped out %d blocks\n",
 				int(tip.Height), int(tip.Height-reorgPoint.Height))
 		}
@@ -411,6 +433,9 @@ func HashToBig(hash *Uint256) *big.Int {
 	return new(big.Int).SetBytes(buf[:])
 }
 
+// Convert the compact difficulty bits to the target number.
+// Taken from btcd blockchain/difficulty.go,
+// Copyright (c) 2013-2017 The btcsuite developers, ISC license.
 func CompactToBig(compact uint32) *big.Int {
 	// Extract the mantissa, sign bit, and exponent.
 	mantissa := compact & 0x007fffff
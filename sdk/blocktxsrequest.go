@@ -4,6 +4,8 @@ import (
 	"errors"
 	"sync"
 
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+
 	"github.com/wuyazero/Elastos.ELA/bloom"
 	. "github.com/wuyazero/Elastos.ELA/core"
 	. "github.com/wuyazero/Elastos.ELA.Utility/common"
@@ -11,6 +13,8 @@ import (
 
 type BlockTxsRequest struct {
 	sync.Mutex
+	// The peer sent the block
+	Peer           *net.Peer
 	BlockHash      Uint256
 	Block          bloom.MerkleBlock
 	txRequestQueue map[Uint256]*Request
@@ -38,6 +42,7 @@ func (req *BlockTxsRequest) OnTxReceived(tx *Transaction) (bool, error) {
 	}
 
 	// Remove from map
+	txRequest.peer.OnDelivered()
 	txRequest.Finish()
 	delete(req.txRequestQueue, txId)
 
@@ -0,0 +1,104 @@
+package sdk
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+
+	. "github.com/wuyazero/Elastos.ELA/core"
+)
+
+var (
+	// A header at a checkpoint height has a different hash
+	ErrCheckpointMismatch = errors.New("header conflicts with checkpoint")
+	// A reorganize would rollback blocks below the last checkpoint
+	ErrReorgBelowCheckpoint = errors.New("reorganize below the last checkpoint")
+)
+
+// Checkpoint is a known good block of the chain, blocks at the checkpoint
+// height must have the checkpoint hash, and the chain will never be
+// reorganized below the last checkpoint it has passed.
+type Checkpoint struct {
+	Height uint32
+	// The block hash in the same format as Uint256.String()
+	Hash string
+}
+
+// The checkpoints of the main net, there are none built in yet, so checkpoints
+// only come from SetCheckpoints. Only add hashes verified against the blocks
+// of trusted full nodes, a wrong hash makes every honest peer banned.
+var MainNetCheckpoints = []Checkpoint{}
+
+// The checkpoints of the test net, there are none built in yet
+var TestNetCheckpoints = []Checkpoint{}
+
+// Return the default checkpoints of the network identified by the magic number
+func DefaultCheckpoints(magic uint32) []Checkpoint {
+	switch magic {
+	case MainNetMagic:
+		return MainNetCheckpoints
+	case TestNetMagic:
+		return TestNetCheckpoints
+	default:
+		return nil
+	}
+}
+
+// Replace the checkpoints of the blockchain
+func (bc *Blockchain) SetCheckpoints(checkpoints []Checkpoint) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.checkpoints = make([]Checkpoint, len(checkpoints))
+	copy(bc.checkpoints, checkpoints)
+	sort.Slice(bc.checkpoints, func(i, j int) bool {
+		return bc.checkpoints[i].Height < bc.checkpoints[j].Height
+	})
+}
+
+// Return the checkpoints of the blockchain in height order
+func (bc *Blockchain) Checkpoints() []Checkpoint {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	checkpoints := make([]Checkpoint, len(bc.checkpoints))
+	copy(checkpoints, bc.checkpoints)
+	return checkpoints
+}
+
+// Check the header against the checkpoint at it's height, return ErrCheckpointMismatch if they conflict
+func (bc *Blockchain) CheckCheckpoint(header Header) error {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.checkCheckpoint(header)
+}
+
+func (bc *Blockchain) checkCheckpoint(header Header) error {
+	for _, checkpoint := range bc.checkpoints {
+		if checkpoint.Height != header.Height {
+			continue
+		}
+		hash := header.Hash()
+		if !strings.EqualFold(hash.String(), checkpoint.Hash) {
+			log.Warnf("Header %s at height %d conflicts with checkpoint %s",
+				hash.String(), header.Height, checkpoint.Hash)
+			return ErrCheckpointMismatch
+		}
+	}
+	return nil
+}
+
+// Return the height of the highest checkpoint not above the given height, zero if there is none
+func (bc *Blockchain) lastCheckpointHeight(height uint32) uint32 {
+	var last uint32
+	for _, checkpoint := range bc.checkpoints {
+		if checkpoint.Height > height {
+			break
+		}
+		last = checkpoint.Height
+	}
+	return last
+}
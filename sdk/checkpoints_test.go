@@ -0,0 +1,167 @@
+package sdk_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk/sdktest"
+
+	"github.com/wuyazero/Elastos.ELA/bloom"
+	"github.com/wuyazero/Elastos.ELA/core"
+)
+
+func TestMain(m *testing.M) {
+	log.Init()
+	os.Exit(m.Run())
+}
+
+func newTestBlockchain(t *testing.T) *sdk.Blockchain {
+	chain, err := sdk.NewBlockchain(sdktest.NewMemStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// Make count headers after the parent, one every interval. The nonce makes
+// headers of different branches different, a nil parent starts from height 1.
+func makeHeaders(parent *core.Header, count int, interval time.Duration, nonce uint32) []core.Header {
+	var prev core.Header
+	if parent == nil {
+		prev = core.Header{
+			Timestamp: uint32(time.Now().Add(-time.Hour * 24 * 30).Unix()),
+			Bits:      sdktest.EasyBits,
+		}
+	} else {
+		prev = *parent
+	}
+
+	headers := make([]core.Header, 0, count)
+	for i := 0; i < count; i++ {
+		header := core.Header{
+			Previous:  prev.Hash(),
+			Timestamp: prev.Timestamp + uint32(interval/time.Second),
+			Bits:      prev.Bits,
+			Nonce:     nonce,
+			Height:    prev.Height + 1,
+		}
+		headers = append(headers, header)
+		prev = header
+	}
+	return headers
+}
+
+func commitHeaders(chain *sdk.Blockchain, headers []core.Header) error {
+	for _, header := range headers {
+		if _, _, err := chain.CommitBlock(bloom.MerkleBlock{Header: header}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashOf(header core.Header) string {
+	hash := header.Hash()
+	return hash.String()
+}
+
+func TestCheckCheckpoint(t *testing.T) {
+	chain := newTestBlockchain(t)
+	main := makeHeaders(nil, 5, sdktest.BlockInterval, 0)
+	fork := makeHeaders(&main[1], 3, sdktest.BlockInterval, 1)
+
+	// Checkpoint hashes are not case sensitive
+	chain.SetCheckpoints([]sdk.Checkpoint{
+		{Height: main[4].Height, Hash: hashOf(main[4])},
+		{Height: main[2].Height, Hash: strings.ToUpper(hashOf(main[2]))},
+	})
+
+	tests := []struct {
+		header core.Header
+		err    error
+	}{
+		{main[1], nil},
+		{main[2], nil},
+		{main[3], nil},
+		{main[4], nil},
+		{fork[0], sdk.ErrCheckpointMismatch},
+		{fork[1], nil},
+		{fork[2], sdk.ErrCheckpointMismatch},
+	}
+	for _, test := range tests {
+		if err := chain.CheckCheckpoint(test.header); err != test.err {
+			t.Errorf("header at height %d returned %v, expect %v", test.header.Height, err, test.err)
+		}
+	}
+
+	// Headers conflicting with a checkpoint are not committed
+	if err := commitHeaders(chain, main[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitHeaders(chain, fork[:1]); err != sdk.ErrCheckpointMismatch {
+		t.Errorf("commit conflicting header returned %v", err)
+	}
+	if err := commitHeaders(chain, main[2:]); err != nil {
+		t.Errorf("commit the checkpointed chain returned %v", err)
+	}
+}
+
+func TestLastCheckpointHeight(t *testing.T) {
+	chain := newTestBlockchain(t)
+	chain.SetCheckpoints([]sdk.Checkpoint{{Height: 200}, {Height: 100}, {Height: 300}})
+
+	tests := []struct {
+		height uint32
+		last   uint32
+	}{
+		{0, 0},
+		{99, 0},
+		{100, 100},
+		{250, 200},
+		{300, 300},
+		{1000, 300},
+	}
+	for _, test := range tests {
+		if last := sdk.LastCheckpointHeight(chain, test.height); last != test.last {
+			t.Errorf("last checkpoint of height %d is %d, expect %d", test.height, last, test.last)
+		}
+	}
+}
+
+func TestReorgBelowCheckpoint(t *testing.T) {
+	chain := newTestBlockchain(t)
+	main := makeHeaders(nil, 6, sdktest.BlockInterval, 0)
+	fork := makeHeaders(&main[1], 5, sdktest.BlockInterval, 1)
+
+	// The fork is stored as a side chain before the checkpoint is known
+	if err := commitHeaders(chain, main); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitHeaders(chain, fork[:4]); err != nil {
+		t.Fatal(err)
+	}
+	chain.SetCheckpoints([]sdk.Checkpoint{{Height: main[3].Height, Hash: hashOf(main[3])}})
+
+	// The fork has more work now, but it forks below the checkpoint
+	if err := commitHeaders(chain, fork[4:]); err != sdk.ErrReorgBelowCheckpoint {
+		t.Errorf("reorganize below checkpoint returned %v", err)
+	}
+	if tip := chain.ChainTip(); tip.Hash() != main[5].Hash() {
+		t.Errorf("chain tip moved to height %d", tip.Height)
+	}
+
+	// Reorganize above the checkpoint is allowed
+	chain = newTestBlockchain(t)
+	fork = makeHeaders(&main[3], 3, sdktest.BlockInterval, 1)
+	if err := commitHeaders(chain, main); err != nil {
+		t.Fatal(err)
+	}
+	chain.SetCheckpoints([]sdk.Checkpoint{{Height: main[3].Height, Hash: hashOf(main[3])}})
+	if err := commitHeaders(chain, fork); err != nil {
+		t.Errorf("reorganize above checkpoint returned %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+package sdk
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/db"
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+
+	. "github.com/wuyazero/Elastos.ELA/core"
+)
+
+/*
+The contextual rules of headers follow the ELA chain, a header is checked
+against the headers it extends before it is committed.
+
+	difficulty  Bits must be the same as the parent, except every BlocksPerRetarget
+	            blocks it is retargeted by the time the last BlocksPerRetarget blocks
+	            took, adjusted by at most RetargetAdjustmentFactor each time
+	past        Timestamp must be after the median time of the previous
+	            MedianTimeBlocks blocks
+	future      Timestamp must not be more than MaxTimeOffset ahead of the local time
+
+The genesis block is not stored, so the difficulty of the first retarget and
+headers without enough stored ancestors are not checked.
+*/
+
+const (
+	// The expected time between two blocks
+	TargetTimePerBlock = time.Minute * 2
+	// The time the blocks between two difficulty retargets are expected to take
+	TargetTimespan = time.Hour * 24
+	// The max factor the difficulty can be adjusted by in one retarget
+	RetargetAdjustmentFactor = 4
+	// How many blocks between two difficulty retargets
+	BlocksPerRetarget = uint32(TargetTimespan / TargetTimePerBlock)
+	// How many previous blocks the median time past is calculated from
+	MedianTimeBlocks = 11
+	// How far a block timestamp can be ahead of the local time
+	MaxTimeOffset = time.Hour * 2
+)
+
+var (
+	// The difficulty bits of a header is not the expected difficulty
+	ErrUnexpectedDifficulty = errors.New("[Blockchain], block difficulty is not the expected difficulty.")
+	// The timestamp of a header is not after the median time past
+	ErrTimeTooOld = errors.New("[Blockchain], block timestamp is not after the median time past.")
+	// The timestamp of a header is too far ahead of the local time
+	ErrTimeTooNew = errors.New("[Blockchain], block timestamp is too far in the future.")
+)
+
+var (
+	minRetargetTimespan = int64(TargetTimespan/time.Second) / RetargetAdjustmentFactor
+	maxRetargetTimespan = int64(TargetTimespan/time.Second) * RetargetAdjustmentFactor
+)
+
+// Check the header against the stored headers it extends, nothing but the
+// timestamp future limit is checked if the parent of the header is not stored.
+func (bc *Blockchain) CheckHeaderContext(header Header) error {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	parent, ok := bc.getStoredPrevious(&db.StoreHeader{Header: header})
+	if !ok {
+		return checkTimestampFuture(header)
+	}
+	return bc.checkHeaderContext(header, parent)
+}
+
+// Return the stored parent of the header, false if it is not stored. The data
+// store returns an empty header as the parent of the first header, the genesis
+// header is not stored, so it is taken as not stored too.
+func (bc *Blockchain) getStoredPrevious(header *db.StoreHeader) (*db.StoreHeader, bool) {
+	parent, err := bc.GetPrevious(header)
+	if err != nil || parent.Height == 0 {
+		return nil, false
+	}
+	return parent, true
+}
+
+func checkTimestampFuture(header Header) error {
+	maxTimestamp := time.Now().Add(MaxTimeOffset).Unix()
+	if int64(header.Timestamp) > maxTimestamp {
+		log.Warnf("Header at height %d timestamp %d is after %d", header.Height, header.Timestamp, maxTimestamp)
+		return ErrTimeTooNew
+	}
+	return nil
+}
+
+func (bc *Blockchain) checkHeaderContext(header Header, parent *db.StoreHeader) error {
+	if err := checkTimestampFuture(header); err != nil {
+		return err
+	}
+
+	medianTime := bc.calcPastMedianTime(parent)
+	if header.Timestamp <= medianTime {
+		log.Warnf("Header at height %d timestamp %d is not after median time past %d",
+			header.Height, header.Timestamp, medianTime)
+		return ErrTimeTooOld
+	}
+
+	bits, ok := bc.calcNextRequiredBits(parent)
+	if ok && header.Bits != bits {
+		log.Warnf("Header at height %d bits %08x, expected %08x", header.Height, header.Bits, bits)
+		return ErrUnexpectedDifficulty
+	}
+
+	return nil
+}
+
+// Return the median timestamp of the header and it's ancestors, at most
+// MedianTimeBlocks of them, less if not enough ancestors stored.
+func (bc *Blockchain) calcPastMedianTime(header *db.StoreHeader) uint32 {
+	timestamps := make([]uint32, 0, MedianTimeBlocks)
+	for i := 0; i < MedianTimeBlocks; i++ {
+		timestamps = append(timestamps, header.Timestamp)
+		var ok bool
+		header, ok = bc.getStoredPrevious(header)
+		if !ok {
+			break
+		}
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// Return the difficulty bits the header after the parent must have,
+// false if they can not be calculated from the stored headers.
+func (bc *Blockchain) calcNextRequiredBits(parent *db.StoreHeader) (uint32, bool) {
+	// Not a retarget block, the difficulty stays the same
+	if (parent.Height+1)%BlocksPerRetarget != 0 {
+		return parent.Bits, true
+	}
+
+	// Get the first block of the retarget interval
+	first := parent
+	for i := uint32(0); i < BlocksPerRetarget-1; i++ {
+		var ok bool
+		first, ok = bc.getStoredPrevious(first)
+		if !ok {
+			log.Debugf("Skip difficulty check at height %d, headers of the retarget interval not stored", parent.Height+1)
+			return 0, false
+		}
+	}
+
+	// Limit the amount of adjustment
+	actualTimespan := int64(parent.Timestamp) - int64(first.Timestamp)
+	adjustedTimespan := actualTimespan
+	if actualTimespan < minRetargetTimespan {
+		adjustedTimespan = minRetargetTimespan
+	} else if actualTimespan > maxRetargetTimespan {
+		adjustedTimespan = maxRetargetTimespan
+	}
+
+	// newTarget = oldTarget * adjustedTimespan / targetTimespan
+	newTarget := new(big.Int).Mul(CompactToBig(parent.Bits), big.NewInt(adjustedTimespan))
+	newTarget.Div(newTarget, big.NewInt(int64(TargetTimespan/time.Second)))
+
+	// The new target can not be easier than the proof of work limit
+	if newTarget.Cmp(PowLimit) > 0 {
+		newTarget.Set(PowLimit)
+	}
+
+	return BigToCompact(newTarget), true
+}
+
+// Convert the target number to the compact difficulty bits, the reverse of CompactToBig.
+// Taken from btcd blockchain/difficulty.go,
+// Copyright (c) 2013-2017 The btcsuite developers, ISC license.
+func BigToCompact(n *big.Int) uint32 {
+	// No need to do any work if it's zero.
+	if n.Sign() == 0 {
+		return 0
+	}
+
+	// Since the base for the exponent is 256, the exponent can be treated
+	// as the number of bytes.  So, shift the number right or left
+	// accordingly.  This is equivalent to:
+	// mantissa = mantissa / 256^(exponent-3)
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		// Use a copy to avoid modifying the caller's original number.
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// When the mantissa already has the sign bit set, the number is too
+	// large to fit into the available 23-bits, so divide the number by 256
+	// and increment the exponent accordingly.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	// Pack the exponent, sign bit, and mantissa into an unsigned 32-bit
+	// int and return it.
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
@@ -0,0 +1,151 @@
+package sdk_test
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA/core"
+)
+
+// Bits harder than the proof of work limit, so the difficulty can be adjusted both ways
+const testBits = 0x1d00ffff
+
+// Commit the headers from height 1 up to the height before the second retarget, the
+// first retarget interval one every two minutes and the second one every interval.
+func newRetargetChain(t *testing.T, interval time.Duration) (*sdk.Blockchain, core.Header) {
+	chain := newTestBlockchain(t)
+	root := core.Header{
+		Timestamp: uint32(time.Now().Add(-time.Hour * 24 * 30).Unix()),
+		Bits:      testBits,
+	}
+	first := makeHeaders(&root, int(sdk.BlocksPerRetarget-1), sdk.TargetTimePerBlock, 0)
+	second := makeHeaders(&first[len(first)-1], int(sdk.BlocksPerRetarget), interval, 0)
+	if err := commitHeaders(chain, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitHeaders(chain, second); err != nil {
+		t.Fatal(err)
+	}
+	return chain, second[len(second)-1]
+}
+
+// The bits of the target adjusted by timespan / TargetTimespan
+func adjustBits(bits uint32, timespan time.Duration) uint32 {
+	target := new(big.Int).Mul(sdk.CompactToBig(bits), big.NewInt(int64(timespan/time.Second)))
+	target.Div(target, big.NewInt(int64(sdk.TargetTimespan/time.Second)))
+	return sdk.BigToCompact(target)
+}
+
+func TestDifficultyRetarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		bits     uint32
+	}{
+		{"on target", sdk.TargetTimePerBlock,
+			adjustBits(testBits, sdk.TargetTimePerBlock*time.Duration(sdk.BlocksPerRetarget-1))},
+		{"too fast", time.Second * 10, 0x1c3fffc0},
+		{"too slow", time.Minute * 20, 0x1d03fffc},
+	}
+
+	for _, test := range tests {
+		chain, parent := newRetargetChain(t, test.interval)
+		if parent.Height+1 != sdk.BlocksPerRetarget*2 {
+			t.Fatalf("parent height %d is not before a retarget", parent.Height)
+		}
+
+		header := makeHeaders(&parent, 1, sdk.TargetTimePerBlock, 0)[0]
+		if err := chain.CheckHeaderContext(header); err != sdk.ErrUnexpectedDifficulty {
+			t.Errorf("%s: unchanged bits returned %v", test.name, err)
+		}
+		header.Bits = test.bits
+		if err := chain.CheckHeaderContext(header); err != nil {
+			t.Errorf("%s: bits %08x returned %v", test.name, test.bits, err)
+		}
+		header.Bits = test.bits + 1
+		if err := chain.CheckHeaderContext(header); err != sdk.ErrUnexpectedDifficulty {
+			t.Errorf("%s: bits %08x returned %v", test.name, header.Bits, err)
+		}
+	}
+}
+
+func TestDifficultyNotRetarget(t *testing.T) {
+	chain := newTestBlockchain(t)
+	root := core.Header{
+		Timestamp: uint32(time.Now().Add(-time.Hour * 24 * 30).Unix()),
+		Bits:      testBits,
+	}
+	headers := makeHeaders(&root, int(sdk.BlocksPerRetarget), sdk.TargetTimePerBlock, 0)
+	if err := commitHeaders(chain, headers[:len(headers)-1]); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		header core.Header
+		bits   uint32
+		err    error
+	}{
+		// Not a retarget block, the bits must be the same as the parent
+		{"same bits", headers[len(headers)-2], testBits, nil},
+		{"changed bits", headers[len(headers)-2], testBits - 1, sdk.ErrUnexpectedDifficulty},
+		// The first retarget interval starts from the genesis block which is
+		// not stored, so the difficulty check is skipped
+		{"first retarget", headers[len(headers)-1], testBits - 1, nil},
+	}
+
+	for _, test := range tests {
+		header := test.header
+		header.Bits = test.bits
+		if err := chain.CheckHeaderContext(header); err != test.err {
+			t.Errorf("%s: header at height %d returned %v, expect %v", test.name, header.Height, err, test.err)
+		}
+	}
+}
+
+func TestHeaderTimestamp(t *testing.T) {
+	chain := newTestBlockchain(t)
+	headers := makeHeaders(nil, sdk.MedianTimeBlocks+5, sdk.TargetTimePerBlock, 0)
+	if err := commitHeaders(chain, headers); err != nil {
+		t.Fatal(err)
+	}
+	parent := headers[len(headers)-1]
+
+	// The median of the last MedianTimeBlocks timestamps
+	medianTime := headers[len(headers)-1-sdk.MedianTimeBlocks/2].Timestamp
+	maxTime := uint32(time.Now().Add(sdk.MaxTimeOffset).Unix())
+
+	tests := []struct {
+		name      string
+		timestamp uint32
+		err       error
+	}{
+		{"before median time", medianTime - 1, sdk.ErrTimeTooOld},
+		{"at median time", medianTime, sdk.ErrTimeTooOld},
+		{"after median time", medianTime + 1, nil},
+		{"before parent", parent.Timestamp - 1, nil},
+		{"now", uint32(time.Now().Unix()), nil},
+		{"too far in future", maxTime + 60, sdk.ErrTimeTooNew},
+	}
+	for _, test := range tests {
+		header := makeHeaders(&parent, 1, sdk.TargetTimePerBlock, 0)[0]
+		header.Timestamp = test.timestamp
+		if err := chain.CheckHeaderContext(header); err != test.err {
+			t.Errorf("%s: timestamp %d returned %v, expect %v", test.name, test.timestamp, err, test.err)
+		}
+	}
+
+	// Only the future limit is checked without the parent stored
+	orphan := makeHeaders(&parent, 2, sdk.TargetTimePerBlock, 0)[1]
+	orphan.Timestamp = medianTime
+	if err := chain.CheckHeaderContext(orphan); err != nil {
+		t.Errorf("orphan header returned %v", err)
+	}
+	orphan.Timestamp = maxTime + 60
+	if err := chain.CheckHeaderContext(orphan); err != sdk.ErrTimeTooNew {
+		t.Errorf("orphan header in future returned %v", err)
+	}
+}
@@ -0,0 +1,36 @@
+package sdk
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+)
+
+// Export the internals for the tests of package sdk_test,
+// which can use sdktest without an import cycle.
+var LastCheckpointHeight = (*Blockchain).lastCheckpointHeight
+
+var NewReplayQueue = newReplayQueue
+
+// The timeout of the request fires when the test sends to the channel after returns
+func NewTestRequest(peer *net.Peer, handler RequestHandler, after func(time.Duration) <-chan time.Time) *Request {
+	return &Request{peer: peer, timeout: time.Second, handler: handler, after: after}
+}
+
+var SetRequestPeer = (*Request).setPeer
+
+var RequestPeer = (*Request).currentPeer
+
+// Return the block requests in flight to the peer
+func Inflight(queue *RequestQueue, peer *net.Peer) int {
+	queue.blockReqsLock.Lock()
+	defer queue.blockReqsLock.Unlock()
+	return queue.inflight[peer]
+}
+
+var CheckSyncStall = (*SPVServiceImpl).checkSyncStall
+
+func SetLastProgress(service *SPVServiceImpl, last time.Time) {
+	atomic.StoreInt64(&service.lastProgress, last.UnixNano())
+}
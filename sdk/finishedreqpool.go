@@ -62,6 +62,31 @@ func (pool *FinishedReqPool) Next(current Uint256) (*BlockTxsRequest, bool) {
 	return nil, false
 }
 
+// Return the lowest block extending a known block other than current, like the first
+// block of a fork below the chain tip. It is used when no block extends current.
+func (pool *FinishedReqPool) NextFork(known func(hash Uint256) bool) (*BlockTxsRequest, bool) {
+	pool.Lock()
+	defer pool.Unlock()
+
+	var next *BlockTxsRequest
+	for previous, request := range pool.requests {
+		if !known(previous) {
+			continue
+		}
+		if next == nil || request.Block.Header.Height < next.Block.Header.Height {
+			next = request
+		}
+	}
+	if next == nil {
+		return nil, false
+	}
+
+	delete(pool.requests, next.Block.Header.Previous)
+	delete(pool.blocks, next.BlockHash)
+	pool.lastPop = &next.BlockHash
+	return next, true
+}
+
 func (pool *FinishedReqPool) LastPop() *Uint256 {
 	return pool.lastPop
 }
@@ -17,6 +17,17 @@ type P2PClient interface {
 	// Start the P2P client
 	Start()
 
+	// Stop the P2P client, disconnect all peers and wait for the network to shut down
+	Stop()
+
+	// Connect only to the given peers, the list can be reloaded by calling it again.
+	// Ports will be overwrite to SPVServerPort like the seeds.
+	SetConnectPeers(addrs []string)
+
+	// Add the node into the address book and connect it now.
+	// The port will be overwrite to SPVServerPort like the seeds.
+	AddNode(addr string)
+
 	// Get the peer manager of this P2P client
 	PeerManager() *net.PeerManager
 }
@@ -40,5 +51,12 @@ type P2PMessageHandler interface {
 // seeds is a list which is the other peers IP:[Port] addresses,
 // port is not necessary for it will be overwrite to SPVServerPort according to the SPV protocol
 func GetP2PClient(magic uint32, clientId uint64, seeds []string) (P2PClient, error) {
-	return NewP2PClientImpl(magic, clientId, seeds)
+	return NewP2PClientImpl(clientId, &net.Config{Magic: magic, SeedList: seeds})
+}
+
+// Get a P2P client with the network config, use this to set a custom Dialer or Listener
+// like a SOCKS5 proxy dialer, or to disable inbound connections.
+// Seed ports in config.SeedList, config.DNSSeeds and config.ConnectPeers will be overwrite to SPVServerPort like GetP2PClient()
+func GetP2PClientWithConfig(clientId uint64, config *net.Config) (P2PClient, error) {
+	return NewP2PClientImpl(clientId, config)
 }
@@ -3,6 +3,8 @@ package sdk
 import (
 	"errors"
 	"fmt"
+	gonet "net"
+	"strconv"
 	"strings"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/net"
@@ -16,20 +18,18 @@ type P2PClientImpl struct {
 	peerManager *net.PeerManager
 }
 
-func NewP2PClientImpl(magic uint32, clientId uint64, seeds []string) (*P2PClientImpl, error) {
+func NewP2PClientImpl(clientId uint64, config *net.Config) (*P2PClientImpl, error) {
 	// Initialize local peer
 	local := new(net.Peer)
 	local.SetID(clientId)
 	local.SetVersion(ProtocolVersion)
 	local.SetPort(SPVClientPort)
 
-	if magic == 0 {
+	if config.Magic == 0 {
 		return nil, errors.New("Magic number has not been set ")
 	}
-	// Set Magic number of the P2P network
-	p2p.Magic = magic
 
-	if len(seeds) == 0 {
+	if len(config.SeedList) == 0 && len(config.DNSSeeds) == 0 && len(config.ConnectPeers) == 0 {
 		return nil, errors.New("Seeds list is empty ")
 	}
 
@@ -37,7 +37,11 @@ func NewP2PClientImpl(magic uint32, clientId uint64, seeds []string) (*P2PClient
 	client := new(P2PClientImpl)
 
 	// Initialize peer manager
-	client.peerManager = net.InitPeerManager(local, toSPVAddr(seeds))
+	netConfig := *config
+	netConfig.SeedList = toSPVAddr(config.SeedList)
+	netConfig.DNSSeeds = toSPVAddr(config.DNSSeeds)
+	netConfig.ConnectPeers = toSPVAddr(config.ConnectPeers)
+	client.peerManager = net.NewPeerManager(local, &netConfig)
 
 	// Set message handler
 	client.peerManager.SetMessageHandler(client)
@@ -54,16 +58,30 @@ func (client *P2PClientImpl) Start() {
 	client.peerManager.Start()
 }
 
-// Convert seed addresses to SPVServerPort according to the SPV protocol
+func (client *P2PClientImpl) Stop() {
+	client.peerManager.Stop()
+}
+
+func (client *P2PClientImpl) SetConnectPeers(addrs []string) {
+	client.peerManager.SetConnectPeers(toSPVAddr(addrs))
+}
+
+func (client *P2PClientImpl) AddNode(addr string) {
+	client.peerManager.AddNode(toSPVAddr([]string{addr})[0])
+}
+
+// Convert seed addresses to SPVServerPort according to the SPV protocol,
+// seeds can be host, host:port, IPv6 address or [IPv6 address]:port
 func toSPVAddr(seeds []string) []string {
 	var addrs = make([]string, len(seeds))
+	port := strconv.Itoa(SPVServerPort)
 	for i, seed := range seeds {
-		portIndex := strings.LastIndex(seed, ":")
-		if portIndex > 0 {
-			addrs[i] = fmt.Sprint(string([]byte(seed)[:portIndex]), ":", SPVServerPort)
-		} else {
-			addrs[i] = fmt.Sprint(seed, ":", SPVServerPort)
+		host, _, err := gonet.SplitHostPort(seed)
+		if err != nil {
+			// No port in seed address
+			host = strings.TrimSuffix(strings.TrimPrefix(seed, "["), "]")
 		}
+		addrs[i] = gonet.JoinHostPort(host, port)
 	}
 	return addrs
 }
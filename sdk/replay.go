@@ -0,0 +1,64 @@
+package sdk
+
+import (
+	"io"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+)
+
+// Max block requests in flight when replaying a capture, the requested blocks
+// arrive in the following records, so the window must never fill up
+const MaxReplayRequests = 10000
+
+// Create a request queue for replaying a capture. Pushed hashes are requested
+// before PushHashes returns, and requests never time out by the wall clock, so
+// the messages are handled in the same order as they were recorded.
+func newReplayQueue(handler RequestQueueHandler) *RequestQueue {
+	queue := NewRequestQueue(MaxReplayRequests, handler)
+	queue.timeout = 0
+	queue.replay = true
+	return queue
+}
+
+// Replay a message capture into the service, the service must not be started.
+// Messages are handled one by one in the recorded order. Blocks synchronizing
+// runs on the capture clock instead of a ticker, whenever InfoUpdateDuration
+// of recorded time has passed, so a capture always replays the same way.
+func (service *SPVServiceImpl) Replay(capture *net.CaptureReader) error {
+	replayer, err := service.PeerManager().NewReplayer(capture)
+	if err != nil {
+		return err
+	}
+	defer replayer.Close()
+
+	service.queue.Stop()
+	service.queue = newReplayQueue(service)
+
+	var nextSync time.Time
+	for {
+		record, err := replayer.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if record.Direction != net.CaptureRecv {
+			continue
+		}
+
+		if nextSync.IsZero() {
+			nextSync = record.Time.Add(time.Second * net.InfoUpdateDuration)
+		}
+		if !record.Time.Before(nextSync) {
+			service.syncBlocks()
+			nextSync = record.Time.Add(time.Second * net.InfoUpdateDuration)
+		}
+
+		err = replayer.Dispatch(record)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -2,6 +2,7 @@ package sdk
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	. "github.com/wuyazero/Elastos.ELA.Utility/common"
@@ -15,47 +16,112 @@ const (
 
 type RequestHandler interface {
 	OnSendRequest(peer *net.Peer, reqType uint8, hash Uint256)
+	// A request timed out and will be sent again, the handler may assign it to another peer
+	OnRequestRetry(r *Request)
 	OnRequestTimeout(Uint256)
 }
 
 type Request struct {
+	peerLock   sync.Mutex
 	peer       *net.Peer
 	hash       Uint256
 	reqType    uint8
 	retryTimes int
-	doneChan   chan byte
+	done       chan struct{}
+	reset      chan struct{}
+	finish     sync.Once
 	handler    RequestHandler
+	// Wait the response for timeout before sending the request again, wait forever if not positive
+	timeout time.Duration
+	// Start the timer of the timeout, time.NewTimer is used if not set
+	after func(time.Duration) <-chan time.Time
 }
 
 func (r *Request) Start() error {
 	if r.handler == nil {
 		return errors.New("RequestHandler not set")
 	}
-	r.doneChan = make(chan byte)
-	go r.sendRequest()
+	r.done = make(chan struct{})
+	r.reset = make(chan struct{}, 1)
+	// The first request is sent before returning, so requests are sent in the order they are started
+	r.sendRequest()
+	if r.timeout > 0 {
+		go r.waitResponse()
+	}
 	return nil
 }
 
 func (r *Request) sendRequest() {
-	r.handler.OnSendRequest(r.peer, r.reqType, r.hash)
-	timer := time.NewTimer(time.Second * RequestTimeout)
+	peer := r.currentPeer()
+	peer.OnRequest()
+	r.handler.OnSendRequest(peer, r.reqType, r.hash)
+}
+
+// Return the peer the request is assigned to
+func (r *Request) currentPeer() *net.Peer {
+	r.peerLock.Lock()
+	defer r.peerLock.Unlock()
+	return r.peer
+}
+
+// Assign the request to another peer, the timeout restarts for the new peer
+func (r *Request) setPeer(peer *net.Peer) {
+	r.peerLock.Lock()
+	r.peer = peer
+	r.peerLock.Unlock()
+
 	select {
-	case <-timer.C:
+	case r.reset <- struct{}{}:
+	default:
+	}
+}
+
+// Return the channel the timeout fires on and the function to stop the timer
+func (r *Request) startTimer() (<-chan time.Time, func() bool) {
+	if r.after != nil {
+		return r.after(r.timeout), func() bool { return true }
+	}
+	timer := time.NewTimer(r.timeout)
+	return timer.C, timer.Stop
+}
+
+// Send the request again every timeout until the response comes or it runs out of retries
+func (r *Request) waitResponse() {
+	for {
+		timeout, stop := r.startTimer()
+		select {
+		case <-timeout:
+		case <-r.reset:
+			// Reassigned, wait the full timeout for the new peer
+			stop()
+			continue
+		case <-r.done:
+			stop()
+			return
+		}
+
+		// The timeout is charged to the peer it was waiting for
+		r.currentPeer().OnRequestTimeout()
 		if r.retryTimes >= MaxRetryTimes {
 			r.Finish()
 			r.handler.OnRequestTimeout(r.hash)
-			break
+			return
 		}
 		r.retryTimes++
+		r.handler.OnRequestRetry(r)
+		// The timer starts over anyway, drop the reset of a reassign on retry
+		select {
+		case <-r.reset:
+		default:
+		}
 		r.sendRequest()
-	case <-r.doneChan:
-		timer.Stop()
 	}
 }
 
+// Stop waiting for the response, it is safe to call Finish more than once
 func (r *Request) Finish() {
-	if r.doneChan != nil {
-		r.doneChan <- 1
-		r.doneChan = nil
+	if r.done == nil {
+		return
 	}
+	r.finish.Do(func() { close(r.done) })
 }
@@ -0,0 +1,97 @@
+package sdk_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+)
+
+// Send the peers a request is sent to, the peers it times out on and the timed out hashes
+type testRequestHandler struct {
+	sent     chan *net.Peer
+	retried  chan *net.Peer
+	timedOut chan common.Uint256
+}
+
+func (h *testRequestHandler) OnSendRequest(peer *net.Peer, reqType uint8, hash common.Uint256) {
+	h.sent <- peer
+}
+
+func (h *testRequestHandler) OnRequestRetry(r *sdk.Request) {
+	h.retried <- sdk.RequestPeer(r)
+}
+
+func (h *testRequestHandler) OnRequestTimeout(hash common.Uint256) {
+	h.timedOut <- hash
+}
+
+// Timers fired by the test, each timer started is sent to the timers channel
+type testTimers chan chan time.Time
+
+func (timers testTimers) after(time.Duration) <-chan time.Time {
+	timer := make(chan time.Time, 1)
+	timers <- timer
+	return timer
+}
+
+func (timers testTimers) next(t *testing.T) chan time.Time {
+	select {
+	case timer := <-timers:
+		return timer
+	case <-time.After(time.Second * 5):
+		t.Fatal("timer not started")
+		return nil
+	}
+}
+
+func TestRequestReassign(t *testing.T) {
+	peer1, close1 := newTestPeer()
+	defer close1()
+	peer2, close2 := newTestPeer()
+	defer close2()
+
+	handler := &testRequestHandler{
+		sent:     make(chan *net.Peer, 10),
+		retried:  make(chan *net.Peer, 10),
+		timedOut: make(chan common.Uint256, 1),
+	}
+	timers := make(testTimers, 10)
+	request := sdk.NewTestRequest(peer1, handler, timers.after)
+	request.Start()
+	defer request.Finish()
+	if peer := <-handler.sent; peer != peer1 {
+		t.Fatal("request not sent to the assigned peer")
+	}
+	timers.next(t)
+
+	// Reassigned before the timeout, the timeout starts over for the new peer
+	sdk.SetRequestPeer(request, peer2)
+	timers.next(t) <- time.Now()
+
+	if peer := <-handler.retried; peer != peer2 {
+		t.Error("timeout charged to the peer before reassigned")
+	}
+	if peer := <-handler.sent; peer != peer2 {
+		t.Error("request retried on the peer before reassigned")
+	}
+
+	// The request times out after the retries run out
+	for i := 1; i < sdk.MaxRetryTimes; i++ {
+		timers.next(t) <- time.Now()
+		<-handler.retried
+		<-handler.sent
+	}
+	timers.next(t) <- time.Now()
+	select {
+	case <-handler.timedOut:
+	case <-time.After(time.Second * 5):
+		t.Fatal("request not timed out after the retries")
+	}
+	if len(handler.sent) > 0 {
+		t.Error("request sent again after timed out")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
 	"github.com/wuyazero/Elastos.ELA.SPV/net"
@@ -14,35 +15,63 @@ import (
 	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
 )
 
+// Max block requests in flight to one peer, more requests go to other peers
+const MaxPeerRequests = 32
+
 type RequestQueueHandler interface {
 	OnSendRequest(peer *net.Peer, reqType uint8, hash Uint256)
 	OnRequestError(error)
 	OnRequestFinished(*FinishedReqPool)
+	// Return the peers to download blocks from
+	DownloadPeers() []*net.Peer
 }
 
+/*
+RequestQueue downloads the blocks pushed by PushHashes from the download peers
+in parallel. Blocks are requested in a sliding window of size blocks, starting
+from the first block not committed yet. Each block request goes to the download
+peer with the fewest requests in flight, and is assigned to another peer when it
+times out or the peer does not have the block. The transactions of a block are
+requested from the peer which sent the block. Finished blocks are committed in
+order through FinishedReqPool, and a window slot is released by OnBlockCommitted.
+*/
 type RequestQueue struct {
 	size             int
-	peer             *net.Peer
-	hashesQueue      chan Uint256
+	hashesQueue      chan pushedHash
 	blocksQueue      chan Uint256
 	blockTxsQueue    chan Uint256
 	blockReqsLock    *sync.Mutex
 	blockRequests    map[Uint256]*Request
+	inflight         map[*net.Peer]int
+	window           map[Uint256]bool
 	blockTxsReqsLock *sync.Mutex
 	blockTxsRequests map[Uint256]*BlockTxsRequest
 	blockTxs         map[Uint256]Uint256
 	finished         *FinishedReqPool
 	handler          RequestQueueHandler
+	quit             chan struct{}
+	// Wait the response for timeout before sending a request again, wait forever if not positive
+	timeout time.Duration
+	// Request the pushed hashes before PushHashes returns, see newReplayQueue
+	replay bool
+}
+
+// A hash pushed with the peer to request it from if there are no other download peers
+type pushedHash struct {
+	peer *net.Peer
+	hash Uint256
 }
 
 func NewRequestQueue(size int, handler RequestQueueHandler) *RequestQueue {
 	queue := new(RequestQueue)
 	queue.size = size
-	queue.hashesQueue = make(chan Uint256, size)
+	queue.hashesQueue = make(chan pushedHash, size)
 	queue.blocksQueue = make(chan Uint256, size)
 	queue.blockTxsQueue = make(chan Uint256, size)
 	queue.blockReqsLock = new(sync.Mutex)
 	queue.blockRequests = make(map[Uint256]*Request)
+	queue.inflight = make(map[*net.Peer]int)
+	queue.window = make(map[Uint256]bool)
 	queue.blockTxsReqsLock = new(sync.Mutex)
 	queue.blockTxsRequests = make(map[Uint256]*BlockTxsRequest)
 	queue.blockTxs = make(map[Uint256]Uint256)
@@ -51,22 +80,41 @@ func NewRequestQueue(size int, handler RequestQueueHandler) *RequestQueue {
 		requests: make(map[Uint256]*BlockTxsRequest),
 	}
 	queue.handler = handler
+	queue.quit = make(chan struct{})
+	queue.timeout = time.Second * RequestTimeout
 
 	go queue.start()
 	return queue
 }
 
 func (queue *RequestQueue) start() {
-	for hash := range queue.hashesQueue {
-		queue.StartBlockRequest(queue.peer, hash)
+	for {
+		select {
+		case pushed := <-queue.hashesQueue:
+			queue.StartBlockRequest(pushed.peer, pushed.hash)
+		case <-queue.quit:
+			return
+		}
 	}
 }
 
-// This method will block when request queue is filled
+// Stop the request queue, pending requests will be cleared
+func (queue *RequestQueue) Stop() {
+	close(queue.quit)
+	queue.Clear()
+}
+
+// This method will block when request queue is filled.
+// The peer is used to request the blocks if there are no other download peers.
 func (queue *RequestQueue) PushHashes(peer *net.Peer, hashes []*Uint256) {
-	queue.peer = peer
+	if queue.replay {
+		for _, hash := range hashes {
+			queue.StartBlockRequest(peer, *hash)
+		}
+		return
+	}
 	for _, hash := range hashes {
-		queue.hashesQueue <- *hash
+		queue.hashesQueue <- pushedHash{peer: peer, hash: *hash}
 	}
 }
 
@@ -79,11 +127,16 @@ func (queue *RequestQueue) StartBlockRequest(peer *net.Peer, hash Uint256) {
 	queue.blocksQueue <- hash
 
 	queue.blockReqsLock.Lock()
+	// Assign the request to the least busy download peer
+	peer = queue.selectPeer(peer, nil)
+	queue.inflight[peer]++
+	queue.window[hash] = true
 	// Create a new block request
 	blockRequest := &Request{
 		peer:    peer,
 		hash:    hash,
 		reqType: p2p.BlockData,
+		timeout: queue.timeout,
 		handler: queue,
 	}
 	// Add to request queue
@@ -100,6 +153,7 @@ func (queue *RequestQueue) StartBlockTxsRequest(peer *net.Peer, block *bloom.Mer
 	if len(txIds) == 0 {
 		// Notify request finished
 		queue.OnRequestFinished(&BlockTxsRequest{
+			Peer:      peer,
 			BlockHash: blockHash,
 			Block:     *block,
 		})
@@ -122,6 +176,7 @@ func (queue *RequestQueue) StartBlockTxsRequest(peer *net.Peer, block *bloom.Mer
 			peer:    peer,
 			hash:    *txId,
 			reqType: p2p.TxData,
+			timeout: queue.timeout,
 			handler: queue,
 		}
 		txRequestQueue[*txId] = txRequest
@@ -129,6 +184,7 @@ func (queue *RequestQueue) StartBlockTxsRequest(peer *net.Peer, block *bloom.Mer
 	}
 
 	blockTxsRequest := &BlockTxsRequest{
+		Peer:           peer,
 		BlockHash:      blockHash,
 		Block:          *block,
 		txRequestQueue: txRequestQueue,
@@ -146,6 +202,89 @@ func (queue *RequestQueue) InBlockRequestQueue(blockHash Uint256) bool {
 	return ok
 }
 
+// Return the download peer with the fewest block requests in flight and not
+// more than MaxPeerRequests, except the excluded peer. Return the fallback peer
+// if none is found. Must be called with blockReqsLock held.
+func (queue *RequestQueue) selectPeer(fallback, exclude *net.Peer) *net.Peer {
+	var selected *net.Peer
+	for _, peer := range queue.handler.DownloadPeers() {
+		if peer == exclude || queue.inflight[peer] >= MaxPeerRequests {
+			continue
+		}
+		if selected == nil || queue.inflight[peer] < queue.inflight[selected] {
+			selected = peer
+		}
+	}
+	if selected == nil {
+		return fallback
+	}
+	return selected
+}
+
+// Move the block request to another peer, must be called with blockReqsLock held.
+// Return false if there is no other peer to move to.
+func (queue *RequestQueue) reassign(request *Request) bool {
+	oldPeer := request.currentPeer()
+	peer := queue.selectPeer(nil, oldPeer)
+	if peer == nil {
+		return false
+	}
+	log.Debug("Reassign block request ", request.hash.String(), " from peer ", oldPeer.ID(), " to ", peer.ID())
+	queue.release(oldPeer)
+	queue.inflight[peer]++
+	request.setPeer(peer)
+	return true
+}
+
+// Count a block request of the peer is no longer in flight
+func (queue *RequestQueue) release(peer *net.Peer) {
+	queue.inflight[peer]--
+	if queue.inflight[peer] <= 0 {
+		delete(queue.inflight, peer)
+	}
+}
+
+// The peer does not have the requested block, send the request to another peer.
+// Return false if the block is not requested from the peer or there is no other peer.
+func (queue *RequestQueue) OnNotFound(peer *net.Peer, hash Uint256) bool {
+	queue.blockReqsLock.Lock()
+	request, ok := queue.blockRequests[hash]
+	if !ok || request.currentPeer() != peer || !queue.reassign(request) {
+		queue.blockReqsLock.Unlock()
+		return false
+	}
+	newPeer := request.currentPeer()
+	queue.blockReqsLock.Unlock()
+
+	newPeer.OnRequest()
+	queue.OnSendRequest(newPeer, p2p.BlockData, hash)
+	return true
+}
+
+// Release the window slot of the block after it is committed, so the next block can be requested
+func (queue *RequestQueue) OnBlockCommitted(blockHash Uint256) {
+	queue.blockReqsLock.Lock()
+	defer queue.blockReqsLock.Unlock()
+
+	if !queue.window[blockHash] {
+		return
+	}
+	delete(queue.window, blockHash)
+	select {
+	case <-queue.blocksQueue:
+	default:
+	}
+}
+
+// Return if the transaction is requested as a part of a block
+func (queue *RequestQueue) IsTxRequested(txId Uint256) bool {
+	queue.blockTxsReqsLock.Lock()
+	defer queue.blockTxsReqsLock.Unlock()
+
+	_, ok := queue.blockTxs[txId]
+	return ok
+}
+
 func (queue *RequestQueue) InBlockTxsRequestQueue(blockHash Uint256) bool {
 	queue.blockTxsReqsLock.Lock()
 	defer queue.blockTxsReqsLock.Unlock()
@@ -167,30 +306,49 @@ func (queue *RequestQueue) OnSendRequest(peer *net.Peer, reqType uint8, hash Uin
 	queue.handler.OnSendRequest(peer, reqType, hash)
 }
 
+// Assign the timed out block request to another peer if there is one
+func (queue *RequestQueue) OnRequestRetry(request *Request) {
+	if request.reqType != p2p.BlockData {
+		return
+	}
+
+	queue.blockReqsLock.Lock()
+	defer queue.blockReqsLock.Unlock()
+
+	if queue.blockRequests[request.hash] != request {
+		return
+	}
+	queue.reassign(request)
+}
+
 func (queue *RequestQueue) OnRequestTimeout(hash Uint256) {
 	queue.handler.OnRequestError(errors.New("Request timeout with hash: " + hash.String()))
 }
 
-func (queue *RequestQueue) OnBlockReceived(block *bloom.MerkleBlock, txIds []*Uint256) error {
+// A requested block is received from the peer, the peer may not be the one
+// the request is assigned to if the request has been reassigned.
+func (queue *RequestQueue) OnBlockReceived(peer *net.Peer, block *bloom.MerkleBlock, txIds []*Uint256) error {
 	queue.blockReqsLock.Lock()
-	defer queue.blockReqsLock.Unlock()
 
 	blockHash := block.Header.Hash()
 	// Check if received block is in the request queue
 	var ok bool
 	var request *Request
 	if request, ok = queue.blockRequests[blockHash]; !ok {
+		queue.blockReqsLock.Unlock()
 		fmt.Println("Unknown block received: ", blockHash.String())
 		return nil
 	}
 
-	// Remove from block request list
+	// Remove from block request list, the window slot is released after the block committed
+	peer.OnDelivered()
 	request.Finish()
+	queue.release(request.currentPeer())
 	delete(queue.blockRequests, blockHash)
-	<-queue.blocksQueue
+	queue.blockReqsLock.Unlock()
 
-	// Request block transactions
-	queue.StartBlockTxsRequest(request.peer, block, txIds)
+	// Request block transactions from the peer which has matched them
+	queue.StartBlockTxsRequest(peer, block, txIds)
 
 	return nil
 }
@@ -262,6 +420,8 @@ func (queue *RequestQueue) Clear() {
 		request.Finish()
 		delete(queue.blockRequests, hash)
 	}
+	queue.inflight = make(map[*net.Peer]int)
+	queue.window = make(map[Uint256]bool)
 	queue.blockReqsLock.Unlock()
 
 	// Clear block txs requests
@@ -0,0 +1,197 @@
+package sdk_test
+
+import (
+	gonet "net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA/bloom"
+	"github.com/wuyazero/Elastos.ELA/core"
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+)
+
+// Count the requests sent by the queue and the peers they are last sent to,
+// blocks are downloaded from the peers
+type testQueueHandler struct {
+	sync.Mutex
+	sent   map[common.Uint256]int
+	sentTo map[common.Uint256]*net.Peer
+	peers  []*net.Peer
+}
+
+func newTestQueueHandler(peers ...*net.Peer) *testQueueHandler {
+	return &testQueueHandler{
+		sent:   make(map[common.Uint256]int),
+		sentTo: make(map[common.Uint256]*net.Peer),
+		peers:  peers,
+	}
+}
+
+func (h *testQueueHandler) OnSendRequest(peer *net.Peer, reqType uint8, hash common.Uint256) {
+	h.Lock()
+	defer h.Unlock()
+	h.sent[hash]++
+	h.sentTo[hash] = peer
+}
+
+func (h *testQueueHandler) OnRequestError(error) {}
+
+func (h *testQueueHandler) OnRequestFinished(*sdk.FinishedReqPool) {}
+
+func (h *testQueueHandler) DownloadPeers() []*net.Peer { return h.peers }
+
+func (h *testQueueHandler) sentCount() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.sent)
+}
+
+func (h *testQueueHandler) lastPeer(hash common.Uint256) *net.Peer {
+	h.Lock()
+	defer h.Unlock()
+	return h.sentTo[hash]
+}
+
+func newTestPeer() (*net.Peer, func()) {
+	local, remote := gonet.Pipe()
+	return net.NewPeer(&net.PeerManager{}, local, false), func() {
+		local.Close()
+		remote.Close()
+	}
+}
+
+func TestReplayQueuePushHashes(t *testing.T) {
+	handler := newTestQueueHandler()
+	queue := sdk.NewReplayQueue(handler)
+	defer queue.Stop()
+
+	// A full inventory is more than a live queue holds
+	hashes := make([]*common.Uint256, 500)
+	for i := range hashes {
+		hashes[i] = &common.Uint256{byte(i), byte(i >> 8)}
+	}
+
+	peer, closePeer := newTestPeer()
+	defer closePeer()
+
+	pushed := make(chan struct{})
+	go func() {
+		queue.PushHashes(peer, hashes)
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("PushHashes blocked when replaying")
+	}
+
+	// All blocks are requested before PushHashes returns
+	if sent := handler.sentCount(); sent != len(hashes) {
+		t.Fatalf("sent %d requests, expect %d", sent, len(hashes))
+	}
+}
+
+func TestSelectPeer(t *testing.T) {
+	peer1, close1 := newTestPeer()
+	defer close1()
+	peer2, close2 := newTestPeer()
+	defer close2()
+	fallback, close3 := newTestPeer()
+	defer close3()
+
+	// The replay queue requests the blocks in PushHashes and never times out
+	handler := newTestQueueHandler(peer1, peer2)
+	queue := sdk.NewReplayQueue(handler)
+	defer queue.Stop()
+
+	hashes := make([]*common.Uint256, sdk.MaxPeerRequests*2+1)
+	for i := range hashes {
+		hashes[i] = &common.Uint256{byte(i), 1}
+	}
+	queue.PushHashes(fallback, hashes)
+
+	// The requests are spread over the download peers up to the cap,
+	// the rest goes to the fallback peer
+	counts := make(map[*net.Peer]int)
+	for _, hash := range hashes {
+		counts[handler.lastPeer(*hash)]++
+	}
+	for _, peer := range []*net.Peer{peer1, peer2} {
+		if counts[peer] != sdk.MaxPeerRequests || sdk.Inflight(queue, peer) != sdk.MaxPeerRequests {
+			t.Errorf("peer sent %d requests, %d in flight, expect %d",
+				counts[peer], sdk.Inflight(queue, peer), sdk.MaxPeerRequests)
+		}
+	}
+	if counts[fallback] != 1 || sdk.Inflight(queue, fallback) != 1 {
+		t.Errorf("fallback peer sent %d requests, %d in flight, expect 1",
+			counts[fallback], sdk.Inflight(queue, fallback))
+	}
+}
+
+func TestNotFoundReassign(t *testing.T) {
+	peer1, close1 := newTestPeer()
+	defer close1()
+	peer2, close2 := newTestPeer()
+	defer close2()
+
+	handler := newTestQueueHandler(peer1, peer2)
+	queue := sdk.NewReplayQueue(handler)
+	defer queue.Stop()
+
+	block := &bloom.MerkleBlock{Header: core.Header{Height: 1}}
+	hash := block.Header.Hash()
+	queue.PushHashes(peer1, []*common.Uint256{&hash})
+	if handler.lastPeer(hash) != peer1 {
+		t.Fatal("block not requested from the least busy peer")
+	}
+
+	// Only the peer the request is assigned to can refuse it
+	if queue.OnNotFound(peer2, hash) {
+		t.Error("request reassigned on notfound from another peer")
+	}
+	if !queue.OnNotFound(peer1, hash) {
+		t.Fatal("request not reassigned on notfound")
+	}
+	if handler.lastPeer(hash) != peer2 || handler.sent[hash] != 2 {
+		t.Error("reassigned request not sent to the other peer")
+	}
+	if sdk.Inflight(queue, peer1) != 0 || sdk.Inflight(queue, peer2) != 1 {
+		t.Errorf("%d and %d requests in flight after reassigned, expect 0 and 1",
+			sdk.Inflight(queue, peer1), sdk.Inflight(queue, peer2))
+	}
+
+	// The request is released from the peer it is reassigned to,
+	// even if the block comes from the peer before
+	queue.OnBlockReceived(peer1, block, nil)
+	if sdk.Inflight(queue, peer1) != 0 || sdk.Inflight(queue, peer2) != 0 {
+		t.Errorf("%d and %d requests in flight after received, expect 0",
+			sdk.Inflight(queue, peer1), sdk.Inflight(queue, peer2))
+	}
+	if queue.OnNotFound(peer2, hash) {
+		t.Error("received request reassigned")
+	}
+}
+
+func TestNotFoundNoOtherPeer(t *testing.T) {
+	peer, closePeer := newTestPeer()
+	defer closePeer()
+
+	handler := newTestQueueHandler(peer)
+	queue := sdk.NewReplayQueue(handler)
+	defer queue.Stop()
+
+	hash := common.Uint256{1}
+	queue.PushHashes(peer, []*common.Uint256{&hash})
+
+	// The request stays with the only peer
+	if queue.OnNotFound(peer, hash) {
+		t.Error("request reassigned without another peer")
+	}
+	if handler.sent[hash] != 1 || sdk.Inflight(queue, peer) != 1 {
+		t.Errorf("request sent %d times, %d in flight, expect 1", handler.sent[hash], sdk.Inflight(queue, peer))
+	}
+}
@@ -0,0 +1,260 @@
+package sdktest
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA/core"
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+	"github.com/wuyazero/Elastos.ELA.Utility/crypto"
+)
+
+const (
+	// The difficulty bits of the scripted blocks, the easiest target under sdk.PowLimit
+	EasyBits = 0x207fffff
+
+	// The time between the timestamps of two scripted blocks
+	BlockInterval = time.Minute * 2
+)
+
+/*
+Chain is a scripted block chain for the fake node to serve. Blocks are mined on
+any known block, so forks can be scripted, and the longest branch is the active
+chain the node announces. All blocks are kept, so blocks of a stale branch can
+still be requested by hash like a real node does.
+*/
+type Chain struct {
+	sync.RWMutex
+	blocks map[common.Uint256]*core.Block
+	best   []*core.Block
+}
+
+// Create a chain with only a genesis block at height 0,
+// the genesis block is never sent to SPV clients.
+func NewChain() *Chain {
+	genesis := &core.Block{
+		Header: core.Header{
+			Timestamp: uint32(time.Now().Add(-time.Hour * 24 * 7).Unix()),
+			Bits:      EasyBits,
+		},
+		Transactions: []*core.Transaction{NewCoinbase(0)},
+	}
+	mine(genesis)
+
+	return &Chain{
+		blocks: map[common.Uint256]*core.Block{genesis.Hash(): genesis},
+		best:   []*core.Block{genesis},
+	}
+}
+
+// Create a coinbase transaction of the height, a nonce attribute makes every coinbase unique
+func NewCoinbase(height uint32, outputs ...*core.Output) *core.Transaction {
+	nonce := core.NewAttribute(core.Nonce, []byte(strconv.FormatInt(rand.Int63(), 10)))
+	return &core.Transaction{
+		TxType:     core.CoinBase,
+		Payload:    &core.PayloadCoinBase{CoinbaseData: []byte(strconv.Itoa(int(height)))},
+		Attributes: []*core.Attribute{&nonce},
+		Inputs:     []*core.Input{},
+		Outputs:    outputs,
+		Programs:   []*core.Program{},
+		LockTime:   height,
+	}
+}
+
+// Create a transfer transaction with the outputs, use it to make transactions matching a bloom filter
+func NewTransaction(outputs ...*core.Output) *core.Transaction {
+	nonce := core.NewAttribute(core.Nonce, []byte(strconv.FormatInt(rand.Int63(), 10)))
+	return &core.Transaction{
+		TxType:     core.TransferAsset,
+		Payload:    &core.PayloadTransferAsset{},
+		Attributes: []*core.Attribute{&nonce},
+		Inputs:     []*core.Input{},
+		Outputs:    outputs,
+		Programs:   []*core.Program{},
+	}
+}
+
+// Make a transaction output paying the value to the program hash
+func NewOutput(programHash common.Uint168, value common.Fixed64) *core.Output {
+	return &core.Output{
+		Value:       value,
+		ProgramHash: programHash,
+	}
+}
+
+// Return the genesis block
+func (c *Chain) Genesis() *core.Block {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.best[0]
+}
+
+// Return the tip of the active chain
+func (c *Chain) Tip() *core.Block {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.best[len(c.best)-1]
+}
+
+// Return the height of the active chain
+func (c *Chain) Height() uint32 {
+	c.RLock()
+	defer c.RUnlock()
+
+	return uint32(len(c.best) - 1)
+}
+
+// Return the block on the active chain at the height
+func (c *Chain) BlockByHeight(height uint32) (*core.Block, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if int(height) >= len(c.best) {
+		return nil, false
+	}
+	return c.best[height], true
+}
+
+// Return the block of the hash, in the active chain or not
+func (c *Chain) Block(hash common.Uint256) (*core.Block, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	block, ok := c.blocks[hash]
+	return block, ok
+}
+
+// Return the transaction of the hash and the block containing it, in the active chain or not
+func (c *Chain) Transaction(hash common.Uint256) (*core.Transaction, *core.Block, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, block := range c.blocks {
+		for _, tx := range block.Transactions {
+			if tx.Hash().IsEqual(hash) {
+				return tx, block, true
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// Return if the block is on the active chain
+func (c *Chain) IsActive(hash common.Uint256) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.isActive(hash)
+}
+
+func (c *Chain) isActive(hash common.Uint256) bool {
+	block, ok := c.blocks[hash]
+	if !ok || int(block.Height) >= len(c.best) {
+		return false
+	}
+	return c.best[block.Height].Hash().IsEqual(hash)
+}
+
+// Mine a block with the transactions on the tip of the active chain
+func (c *Chain) Mine(txs ...*core.Transaction) *core.Block {
+	block, _ := c.MineOn(c.Tip().Hash(), txs...)
+	return block
+}
+
+// Mine count empty blocks on the tip of the active chain, the mined blocks are returned
+func (c *Chain) MineBlocks(count int) []*core.Block {
+	blocks := make([]*core.Block, 0, count)
+	for i := 0; i < count; i++ {
+		blocks = append(blocks, c.Mine())
+	}
+	return blocks
+}
+
+// Mine a block with the transactions on the block of the previous hash.
+// The block becomes the new tip if it makes the longest chain,
+// which reorganizes the active chain if previous is not the tip.
+func (c *Chain) MineOn(previous common.Uint256, txs ...*core.Transaction) (*core.Block, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	parent, ok := c.blocks[previous]
+	if !ok {
+		return nil, errors.New("previous block not found")
+	}
+
+	height := parent.Height + 1
+	block := &core.Block{
+		Header: core.Header{
+			Version:   parent.Version,
+			Previous:  previous,
+			Timestamp: parent.Timestamp + uint32(BlockInterval/time.Second),
+			Bits:      parent.Bits,
+			Height:    height,
+		},
+		Transactions: append([]*core.Transaction{NewCoinbase(height)}, txs...),
+	}
+	mine(block)
+	c.blocks[block.Hash()] = block
+
+	// Switch to the branch of the new block if it is the longest,
+	// the genesis block is always active so the loop stops at the fork point
+	if int(height) >= len(c.best) {
+		best := make([]*core.Block, height+1)
+		b := block
+		for !c.isActive(b.Hash()) {
+			best[b.Height] = b
+			b = c.blocks[b.Previous]
+		}
+		copy(best, c.best[:b.Height+1])
+		c.best = best
+	}
+
+	return block, nil
+}
+
+// Mine count blocks on the active chain block at the fork height, the new branch
+// becomes the active chain if it is longer. The mined blocks are returned.
+func (c *Chain) Fork(height uint32, count int) ([]*core.Block, error) {
+	parent, ok := c.BlockByHeight(height)
+	if !ok {
+		return nil, errors.New("fork height beyond the chain tip")
+	}
+
+	blocks := make([]*core.Block, 0, count)
+	previous := parent.Hash()
+	for i := 0; i < count; i++ {
+		block, err := c.MineOn(previous)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+		previous = block.Hash()
+	}
+	return blocks, nil
+}
+
+// Set the merkle root and find a nonce of the aux pow parent block header,
+// which makes the block pass the proof of work check of the SPV client
+func mine(block *core.Block) {
+	hashes := make([]common.Uint256, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		hashes = append(hashes, tx.Hash())
+	}
+	block.MerkleRoot, _ = crypto.ComputeRoot(hashes)
+
+	target := sdk.CompactToBig(block.Bits)
+	for nonce := uint32(0); ; nonce++ {
+		block.AuxPow.ParBlockHeader.Nonce = nonce
+		hash := block.AuxPow.ParBlockHeader.Hash()
+		if sdk.HashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+	}
+}
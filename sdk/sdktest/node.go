@@ -0,0 +1,286 @@
+package sdktest
+
+import (
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	gonet "net"
+	"os"
+	"sync"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA/bloom"
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p/msg"
+)
+
+// Max block hashes in an inventory message, as ELA full nodes reply to getblocks
+const MaxBlocksPerInv = 500
+
+/*
+Node is a fake ELA full node serving SPV clients in process. It runs a
+net.PeerManager listening on a net.PipeNetwork address, so it does version and
+verack handshake, getaddr and addr like a real node, and answers the SPV
+messages from the scripted Chain:
+
+	filterload  loads the bloom filter of the peer
+	getblocks   replies an inventory of the active chain after the locator
+	getdata     replies a merkleblock for a block, or a tx for a transaction
+	ping, pong  replies pong with the chain height, and updates the peer height
+
+Requests can be scripted to get a notfound reply, or no reply at all so the
+client times out. Node does not connect to any peers by itself.
+*/
+type Node struct {
+	pm      *net.PeerManager
+	chain   *Chain
+	dataDir string
+
+	lock     sync.Mutex
+	filters  map[*net.Peer]*bloom.Filter
+	notFound map[common.Uint256]bool
+	dropped  map[common.Uint256]bool
+	requests map[common.Uint256]int
+}
+
+// Create a node of the network identified by the magic number,
+// listening on the address in ip:port format in the pipe network.
+func NewNode(network *net.PipeNetwork, addr string, magic uint32, chain *Chain) (*Node, error) {
+	listener, err := network.Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	// Every node has it's own address book and ban list
+	dataDir, err := ioutil.TempDir("", "sdktest-node")
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	local := new(net.Peer)
+	local.SetID(rand.Uint64())
+	local.SetVersion(sdk.ProtocolVersion)
+	local.SetServices(sdk.ServiveSPV)
+	local.SetPort(uint16(listener.Addr().(*gonet.TCPAddr).Port))
+	local.SetHeight(uint64(chain.Height()))
+
+	node := &Node{
+		chain:    chain,
+		dataDir:  dataDir,
+		filters:  make(map[*net.Peer]*bloom.Filter),
+		notFound: make(map[common.Uint256]bool),
+		dropped:  make(map[common.Uint256]bool),
+		requests: make(map[common.Uint256]int),
+	}
+	node.pm = net.NewPeerManager(local, &net.Config{
+		Magic:    magic,
+		Dialer:   network,
+		Listener: listener,
+		DataDir:  dataDir,
+	})
+	node.pm.SetMessageHandler(node)
+	node.pm.AddEventListener(node)
+	return node, nil
+}
+
+// Start accepting connections
+func (node *Node) Start() {
+	node.pm.Start()
+}
+
+// Stop the node, disconnect all peers and remove it's data directory
+func (node *Node) Stop() {
+	node.pm.Stop()
+	os.RemoveAll(node.dataDir)
+}
+
+func (node *Node) PeerManager() *net.PeerManager {
+	return node.pm
+}
+
+func (node *Node) Chain() *Chain {
+	return node.chain
+}
+
+// Tell the connected peers the height of the chain by a ping message,
+// call it after the chain has changed so clients start to sync.
+// SPV clients do not ask for relay, so the ping is sent to every
+// established peer instead of broadcast.
+func (node *Node) Announce() {
+	height := node.chain.Height()
+	node.pm.Local().SetHeight(uint64(height))
+	for _, peer := range node.pm.ConnectedPeers() {
+		if peer.State() == p2p.ESTABLISH {
+			peer.Send(msg.NewPing(height))
+		}
+	}
+}
+
+// Reply notfound to the data requests of the block or transaction hash, or stop doing it
+func (node *Node) SetNotFound(hash common.Uint256, notFound bool) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	node.notFound[hash] = notFound
+}
+
+// Ignore the data requests of the block or transaction hash, or stop doing it
+func (node *Node) SetDrop(hash common.Uint256, drop bool) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	node.dropped[hash] = drop
+}
+
+// Return how many times the block or transaction hash has been requested
+func (node *Node) Requests(hash common.Uint256) int {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	return node.requests[hash]
+}
+
+func (node *Node) MakeMessage(cmd string) (message p2p.Message, err error) {
+	switch cmd {
+	case "ping":
+		message = new(msg.Ping)
+	case "pong":
+		message = new(msg.Pong)
+	case "filterload":
+		message = new(msg.FilterLoad)
+	case "getblocks":
+		message = new(msg.BlocksReq)
+	case "getdata":
+		message = new(msg.DataReq)
+	default:
+		return nil, errors.New("Received unsupported message, CMD " + cmd)
+	}
+	return message, nil
+}
+
+func (node *Node) OnHandshake(v *msg.Version) error {
+	return nil
+}
+
+func (node *Node) OnPeerEstablish(peer *net.Peer) {}
+
+func (node *Node) HandleMessage(peer *net.Peer, message p2p.Message) error {
+	switch msg := message.(type) {
+	case *msg.Ping:
+		return node.OnPing(peer, msg)
+	case *msg.Pong:
+		return node.OnPong(peer, msg)
+	case *msg.FilterLoad:
+		return node.OnFilterLoad(peer, msg)
+	case *msg.BlocksReq:
+		return node.OnBlocksReq(peer, msg)
+	case *msg.DataReq:
+		return node.OnDataReq(peer, msg)
+	default:
+		return errors.New("handle message unknown type")
+	}
+}
+
+// Remove the bloom filter of the disconnected peer
+func (node *Node) OnEvent(event net.Event) {
+	if event.Type != net.EventPeerDisconnected {
+		return
+	}
+	node.lock.Lock()
+	delete(node.filters, event.Peer)
+	node.lock.Unlock()
+}
+
+func (node *Node) OnPing(peer *net.Peer, p *msg.Ping) error {
+	peer.SetHeight(p.Height)
+	peer.Send(msg.NewPong(node.chain.Height()))
+	return nil
+}
+
+func (node *Node) OnPong(peer *net.Peer, p *msg.Pong) error {
+	peer.OnPong()
+	peer.SetHeight(p.Height)
+	return nil
+}
+
+func (node *Node) OnFilterLoad(peer *net.Peer, filterLoad *msg.FilterLoad) error {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	node.filters[peer] = bloom.LoadFilter(filterLoad)
+	return nil
+}
+
+// Reply the hashes of the active chain after the first locator hash found on it,
+// or after the genesis block if none is found. No reply if there are no more blocks.
+func (node *Node) OnBlocksReq(peer *net.Peer, req *msg.BlocksReq) error {
+	var start uint32
+	for _, hash := range req.Locator {
+		if block, ok := node.chain.Block(*hash); ok && node.chain.IsActive(*hash) {
+			start = block.Height
+			break
+		}
+	}
+
+	var hashes []*common.Uint256
+	for height := start + 1; len(hashes) < MaxBlocksPerInv; height++ {
+		block, ok := node.chain.BlockByHeight(height)
+		if !ok {
+			break
+		}
+		hash := block.Hash()
+		hashes = append(hashes, &hash)
+		if hash.IsEqual(req.HashStop) {
+			break
+		}
+	}
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	peer.Send(&msg.Inventory{Type: p2p.BlockData, Hashes: hashes})
+	return nil
+}
+
+func (node *Node) OnDataReq(peer *net.Peer, req *msg.DataReq) error {
+	node.lock.Lock()
+	node.requests[req.Hash]++
+	dropped := node.dropped[req.Hash]
+	notFound := node.notFound[req.Hash]
+	filter := node.filters[peer]
+	node.lock.Unlock()
+
+	if dropped {
+		return nil
+	}
+	if notFound {
+		peer.Send(&msg.NotFound{Hash: req.Hash})
+		return nil
+	}
+
+	switch req.Type {
+	case p2p.BlockData:
+		block, ok := node.chain.Block(req.Hash)
+		if !ok || filter == nil {
+			peer.Send(&msg.NotFound{Hash: req.Hash})
+			return nil
+		}
+		merkleBlock, _ := bloom.NewMerkleBlock(block, filter)
+		peer.Send(merkleBlock)
+
+	case p2p.TxData:
+		tx, _, ok := node.chain.Transaction(req.Hash)
+		if !ok {
+			peer.Send(&msg.NotFound{Hash: req.Hash})
+			return nil
+		}
+		peer.Send(tx)
+
+	default:
+		return errors.New("unknown data request type")
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package sdktest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+
+	"github.com/wuyazero/Elastos.ELA/bloom"
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+)
+
+const (
+	testMagic     = sdk.TestNetMagic
+	testNodeAddr  = "127.0.0.1:20866"
+	testNodeAddr2 = "127.0.0.2:20866"
+	syncTimeout   = time.Second * 30
+)
+
+func TestMain(m *testing.M) {
+	log.Init()
+	os.Exit(m.Run())
+}
+
+type testEnv struct {
+	node    *Node
+	nodes   []*Node
+	store   *MemStore
+	service *sdk.SPVServiceImpl
+	dataDir string
+}
+
+// Start a node serving the chain and a SPV service syncing from it,
+// the filter matches the outputs paying to the program hash
+func startTestEnv(t *testing.T, chain *Chain, programHash common.Uint168) *testEnv {
+	return startTestEnvWithNodes(t, chain, programHash, testNodeAddr)
+}
+
+// Start nodes on the addresses serving the same chain and a SPV service syncing from them
+func startTestEnvWithNodes(t *testing.T, chain *Chain, programHash common.Uint168, addrs ...string) *testEnv {
+	network := net.NewPipeNetwork()
+	var nodes []*Node
+	for _, addr := range addrs {
+		node, err := NewNode(network, addr, testMagic, chain)
+		if err != nil {
+			t.Fatal("create node failed, ", err)
+		}
+		node.Start()
+		nodes = append(nodes, node)
+	}
+
+	// The client saves addresses and bans apart from the nodes of the same network
+	dataDir, err := ioutil.TempDir("", "sdktest-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, store := newTestService(t, &net.Config{
+		Magic:         testMagic,
+		SeedList:      addrs,
+		Dialer:        network,
+		DisableListen: true,
+		DataDir:       dataDir,
+		CaptureFile:   filepath.Join(dataDir, "capture"),
+	}, programHash)
+	service.Start()
+
+	return &testEnv{node: nodes[0], nodes: nodes, store: store, service: service, dataDir: dataDir}
+}
+
+// Create a SPV service of the config with an empty store, the filter matches the outputs paying to the program hash
+func newTestService(t *testing.T, config *net.Config, programHash common.Uint168) (*sdk.SPVServiceImpl, *MemStore) {
+	client, err := sdk.GetSPVClientWithConfig(1, config)
+	if err != nil {
+		t.Fatal("create client failed, ", err)
+	}
+
+	store := NewMemStore()
+	service, err := sdk.NewSPVServiceImpl(client, store, func() *bloom.Filter {
+		return sdk.BuildBloomFilter([]*common.Uint168{&programHash}, nil)
+	})
+	if err != nil {
+		t.Fatal("create service failed, ", err)
+	}
+	return service, store
+}
+
+func (env *testEnv) stop() {
+	env.service.Stop()
+	for _, node := range env.nodes {
+		node.Stop()
+	}
+	os.RemoveAll(env.dataDir)
+}
+
+// Wait until the client has synced to the tip of the node chain
+func (env *testEnv) waitSynced(t *testing.T) {
+	tip := env.node.Chain().Tip()
+	deadline := time.Now().Add(syncTimeout)
+	for time.Now().Before(deadline) {
+		hash, height := env.store.Tip()
+		if hash.IsEqual(tip.Hash()) && height == tip.Height {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	_, height := env.store.Tip()
+	t.Fatalf("client not synced, height %d, node height %d", height, tip.Height)
+}
+
+func TestSync(t *testing.T) {
+	programHash := common.Uint168{0x21, 1}
+	chain := NewChain()
+	chain.MineBlocks(5)
+	tx := NewTransaction(NewOutput(programHash, 100))
+	chain.Mine(tx)
+	chain.MineBlocks(4)
+
+	env := startTestEnv(t, chain, programHash)
+	defer env.stop()
+	env.waitSynced(t)
+
+	storeTx, ok := env.store.GetTx(tx.Hash())
+	if !ok {
+		t.Fatal("matched transaction not committed")
+	}
+	if storeTx.Height != 6 {
+		t.Errorf("matched transaction committed at height %d, expect 6", storeTx.Height)
+	}
+
+	// New blocks are synced after announced
+	chain.MineBlocks(3)
+	env.node.Announce()
+	env.waitSynced(t)
+}
+
+// Signal the channel when a peer is connected
+type connectedListener chan struct{}
+
+func (l connectedListener) OnEvent(event net.Event) {
+	if event.Type != net.EventPeerConnected {
+		return
+	}
+	select {
+	case l <- struct{}{}:
+	default:
+	}
+}
+
+func TestReplay(t *testing.T) {
+	programHash := common.Uint168{0x21, 5}
+	chain := NewChain()
+	chain.MineBlocks(5)
+	tx := NewTransaction(NewOutput(programHash, 100))
+	chain.Mine(tx)
+	chain.MineBlocks(4)
+
+	env := startTestEnv(t, chain, programHash)
+	defer env.stop()
+	env.waitSynced(t)
+	// Stop the service to close the capture
+	env.service.Stop()
+
+	file, err := os.Open(filepath.Join(env.dataDir, "capture"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	capture, err := net.NewCaptureReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replay the handshake and the sync into a new service, which is not started
+	service, store := newTestService(t, &net.Config{
+		Magic:         testMagic,
+		SeedList:      []string{testNodeAddr},
+		DisableListen: true,
+		DataDir:       env.dataDir,
+	}, programHash)
+	defer service.Stop()
+	connected := make(chan struct{}, 1)
+	service.PeerManager().AddEventListener(connectedListener(connected))
+	err = service.Replay(capture)
+	if err != nil {
+		t.Fatal("replay failed, ", err)
+	}
+
+	// The replayed peer finished the handshake
+	select {
+	case <-connected:
+	case <-time.After(time.Second):
+		t.Fatal("replayed peer not connected")
+	}
+
+	tip := chain.Tip()
+	hash, height := store.Tip()
+	if !hash.IsEqual(tip.Hash()) || height != tip.Height {
+		t.Fatalf("replayed to height %d, expect %d", height, tip.Height)
+	}
+	if _, ok := store.GetTx(tx.Hash()); !ok {
+		t.Error("matched transaction not replayed")
+	}
+}
+
+func TestReorganize(t *testing.T) {
+	programHash := common.Uint168{0x21, 2}
+	chain := NewChain()
+	chain.MineBlocks(4)
+	tx := NewTransaction(NewOutput(programHash, 100))
+	chain.Mine(tx)
+
+	env := startTestEnv(t, chain, programHash)
+	defer env.stop()
+	env.waitSynced(t)
+
+	// Replace the block with the transaction by a longer branch
+	_, err := chain.Fork(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.node.Announce()
+	env.waitSynced(t)
+
+	if _, ok := env.store.GetTx(tx.Hash()); ok {
+		t.Error("transaction of the orphaned block not rolled back")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	chain := NewChain()
+	chain.MineBlocks(6)
+	missing, _ := chain.BlockByHeight(3)
+
+	env := startTestEnv(t, chain, common.Uint168{0x21, 3})
+	defer env.stop()
+
+	env.node.SetNotFound(missing.Hash(), true)
+	deadline := time.Now().Add(syncTimeout)
+	for env.node.Requests(missing.Hash()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if env.node.Requests(missing.Hash()) == 0 {
+		t.Fatal("missing block not requested")
+	}
+	if _, height := env.store.Tip(); height >= 3 {
+		t.Fatalf("synced to height %d beyond the missing block", height)
+	}
+
+	// The client reconnects and syncs after the block is found
+	env.node.SetNotFound(missing.Hash(), false)
+	env.waitSynced(t)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("request timeout takes more than ", sdk.RequestTimeout, " seconds")
+	}
+
+	chain := NewChain()
+	chain.MineBlocks(3)
+	dropped, _ := chain.BlockByHeight(2)
+
+	env := startTestEnv(t, chain, common.Uint168{0x21, 4})
+	defer env.stop()
+
+	// The request is sent again after timeout
+	env.node.SetDrop(dropped.Hash(), true)
+	deadline := time.Now().Add(syncTimeout + time.Second*sdk.RequestTimeout)
+	for env.node.Requests(dropped.Hash()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if env.node.Requests(dropped.Hash()) < 2 {
+		t.Fatal("dropped block not requested again")
+	}
+
+	env.node.SetDrop(dropped.Hash(), false)
+	env.waitSynced(t)
+}
+
+func TestParallelDownload(t *testing.T) {
+	programHash := common.Uint168{0x21, 5}
+	chain := NewChain()
+	chain.MineBlocks(100)
+	tx := NewTransaction(NewOutput(programHash, 100))
+	chain.Mine(tx)
+	chain.MineBlocks(99)
+
+	env := startTestEnvWithNodes(t, chain, programHash, testNodeAddr, testNodeAddr2)
+	defer env.stop()
+	env.waitSynced(t)
+
+	if _, ok := env.store.GetTx(tx.Hash()); !ok {
+		t.Fatal("matched transaction not committed")
+	}
+
+	// Blocks are requested from both nodes
+	for i, node := range env.nodes {
+		var requested int
+		for height := uint32(1); height <= chain.Height(); height++ {
+			block, _ := chain.BlockByHeight(height)
+			if node.Requests(block.Hash()) > 0 {
+				requested++
+			}
+		}
+		if requested == 0 {
+			t.Errorf("no blocks requested from node %d", i)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package sdktest
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/db"
+
+	"github.com/wuyazero/Elastos.ELA.Utility/common"
+)
+
+// MemStore is an in-memory db.DataStore, every transaction committed is kept and none is a false positive
+type MemStore struct {
+	sync.RWMutex
+	headers map[common.Uint256]*db.StoreHeader
+	tip     *db.StoreHeader
+	height  uint32
+	txs     map[common.Uint256]*db.StoreTx
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		headers: make(map[common.Uint256]*db.StoreHeader),
+		txs:     make(map[common.Uint256]*db.StoreTx),
+	}
+}
+
+func (s *MemStore) PutHeader(header *db.StoreHeader, newTip bool) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.headers[header.Hash()] = header
+	if newTip {
+		s.tip = header
+	}
+	return nil
+}
+
+func (s *MemStore) GetPrevious(header *db.StoreHeader) (*db.StoreHeader, error) {
+	if header.Height == 1 {
+		return &db.StoreHeader{TotalWork: new(big.Int)}, nil
+	}
+	return s.GetHeader(header.Previous)
+}
+
+func (s *MemStore) GetHeader(hash common.Uint256) (*db.StoreHeader, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	header, ok := s.headers[hash]
+	if !ok {
+		return nil, errors.New("header not found")
+	}
+	return header, nil
+}
+
+func (s *MemStore) GetChainTip() (*db.StoreHeader, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.tip == nil {
+		return nil, errors.New("chain tip not found")
+	}
+	return s.tip, nil
+}
+
+func (s *MemStore) PutChainHeight(height uint32) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.height = height
+}
+
+func (s *MemStore) GetChainHeight() uint32 {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.height
+}
+
+func (s *MemStore) CommitTx(tx *db.StoreTx) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.txs[tx.TxId] = tx
+	return false, nil
+}
+
+func (s *MemStore) Rollback(height uint32) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for txId, tx := range s.txs {
+		if tx.Height == height {
+			delete(s.txs, txId)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Reset() error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.headers = make(map[common.Uint256]*db.StoreHeader)
+	s.tip = nil
+	s.height = 0
+	s.txs = make(map[common.Uint256]*db.StoreTx)
+	return nil
+}
+
+func (s *MemStore) Close() {}
+
+// Return the committed transaction of the hash
+func (s *MemStore) GetTx(txId common.Uint256) (*db.StoreTx, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	tx, ok := s.txs[txId]
+	return tx, ok
+}
+
+// Return the hash of the chain tip and the chain height
+func (s *MemStore) Tip() (common.Uint256, uint32) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.tip == nil {
+		return common.Uint256{}, s.height
+	}
+	return s.tip.Hash(), s.height
+}
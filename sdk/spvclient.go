@@ -23,6 +23,17 @@ type SPVClient interface {
 	// Start the client
 	Start()
 
+	// Stop the client and the peer to peer network
+	Stop()
+
+	// Connect only to the given peers, the list can be reloaded by calling it again.
+	// Ports will be overwrite to SPVServerPort like the seeds.
+	SetConnectPeers(addrs []string)
+
+	// Add the node into the address book and connect it now.
+	// The port will be overwrite to SPVServerPort like the seeds.
+	AddNode(addr string)
+
 	// Get peer manager, which is the main program of the peer to peer network
 	PeerManager() *net.PeerManager
 }
@@ -74,5 +85,11 @@ func GetSPVClient(netType string, clientId uint64, seeds []string) (SPVClient, e
 	default:
 		return nil, errors.New("Unknown net type ")
 	}
-	return NewSPVClientImpl(magic, clientId, seeds)
+	return NewSPVClientImpl(clientId, &net.Config{Magic: magic, SeedList: seeds})
+}
+
+// Get the SPV client with the network config, config.Magic can be MainNetMagic or TestNetMagic.
+// Use this to set a custom Dialer or Listener like a SOCKS5 proxy dialer, or to disable inbound connections.
+func GetSPVClientWithConfig(clientId uint64, config *net.Config) (SPVClient, error) {
+	return NewSPVClientImpl(clientId, config)
 }
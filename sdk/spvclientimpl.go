@@ -2,8 +2,10 @@ package sdk
 
 import (
 	"errors"
+	"sync"
 	"time"
 
+	"github.com/wuyazero/Elastos.ELA.SPV/log"
 	"github.com/wuyazero/Elastos.ELA.SPV/net"
 
 	"github.com/wuyazero/Elastos.ELA/bloom"
@@ -15,16 +17,18 @@ import (
 type SPVClientImpl struct {
 	p2p        P2PClient
 	msgHandler SPVMessageHandler
+	quit       chan struct{}
+	wg         sync.WaitGroup
 }
 
-func NewSPVClientImpl(magic uint32, clientId uint64, seeds []string) (*SPVClientImpl, error) {
+func NewSPVClientImpl(clientId uint64, config *net.Config) (*SPVClientImpl, error) {
 	// Initialize P2P client
-	p2p, err := GetP2PClient(magic, clientId, seeds)
+	p2p, err := GetP2PClientWithConfig(clientId, config)
 	if err != nil {
 		return nil, err
 	}
 
-	client := &SPVClientImpl{p2p: p2p}
+	client := &SPVClientImpl{p2p: p2p, quit: make(chan struct{})}
 	p2p.SetMessageHandler(client)
 
 	return client, nil
@@ -36,6 +40,27 @@ func (client *SPVClientImpl) SetMessageHandler(handler SPVMessageHandler) {
 
 func (client *SPVClientImpl) Start() {
 	client.p2p.Start()
+	client.wg.Add(1)
+	go client.keepUpdate()
+}
+
+func (client *SPVClientImpl) Stop() {
+	select {
+	case <-client.quit:
+		return
+	default:
+		close(client.quit)
+	}
+	client.wg.Wait()
+	client.p2p.Stop()
+}
+
+func (client *SPVClientImpl) SetConnectPeers(addrs []string) {
+	client.p2p.SetConnectPeers(addrs)
+}
+
+func (client *SPVClientImpl) AddNode(addr string) {
+	client.p2p.AddNode(addr)
 }
 
 func (client *SPVClientImpl) PeerManager() *net.PeerManager {
@@ -88,33 +113,52 @@ func (client *SPVClientImpl) OnPeerEstablish(peer *net.Peer) {
 func (client *SPVClientImpl) OnPing(peer *net.Peer, p *msg.Ping) error {
 	peer.SetHeight(p.Height)
 	// Return pong message to peer
-	go peer.Send(msg.NewPong(uint32(client.PeerManager().Local().Height())))
+	peer.Send(msg.NewPong(uint32(client.PeerManager().Local().Height())))
 	return nil
 }
 
 func (client *SPVClientImpl) OnPong(peer *net.Peer, p *msg.Pong) error {
+	peer.OnPong()
 	peer.SetHeight(p.Height)
 	return nil
 }
 
 func (client *SPVClientImpl) keepUpdate() {
+	defer client.wg.Done()
 	ticker := time.NewTicker(time.Second * net.InfoUpdateDuration)
 	defer ticker.Stop()
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-client.quit:
+			return
+		}
 
 		// Update peers info
-		for _, peer := range client.PeerManager().ConnectedPeers() {
+		pm := client.PeerManager()
+		for _, peer := range pm.ConnectedPeers() {
 			if peer.State() == p2p.ESTABLISH {
 
-				// Disconnect inactive peer
-				if peer.LastActive().Before(
-					time.Now().Add(-time.Second * net.InfoUpdateDuration * net.KeepAliveTimeout)) {
-					client.PeerManager().DisconnectPeer(peer)
+				// Disconnect inactive peer, any message received
+				// keeps the peer alive, not only the pong
+				if time.Since(peer.LastRecv()) > pm.PingTimeout() {
+					log.Info("Disconnect inactive peer ", peer.ID())
+					pm.DisconnectPeerWithReason(peer, "ping timeout")
+					continue
+				}
+
+				// Disconnect peer not answering pings, though it sends other messages
+				if ping := peer.PingTime(); !ping.IsZero() && time.Since(ping) > pm.PingTimeout() {
+					log.Info("Disconnect peer ", peer.ID(), " not answering pings")
+					pm.DisconnectPeerWithReason(peer, "ping not answered")
 					continue
 				}
 
-				// Send ping message to peer
-				go peer.Send(msg.NewPing(uint32(client.PeerManager().Local().Height())))
+				// Send ping message to peer every ping interval
+				if time.Since(peer.LastPing()) >= pm.PingInterval() {
+					peer.StartPing()
+					peer.Send(msg.NewPing(uint32(pm.Local().Height())))
+				}
 			}
 		}
 	}
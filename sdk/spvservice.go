@@ -2,6 +2,7 @@ package sdk
 
 import (
 	"github.com/wuyazero/Elastos.ELA.SPV/db"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
 
 	"github.com/wuyazero/Elastos.ELA/bloom"
 	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
@@ -28,6 +29,10 @@ type SPVService interface {
 
 	// Broadcast a message to the peer to peer network.
 	BroadCastMessage(message p2p.Message)
+
+	// Get the peer manager of the peer to peer network,
+	// use it to get network statistics or listen to network events.
+	PeerManager() *net.PeerManager
 }
 
 /*
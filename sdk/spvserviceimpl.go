@@ -3,12 +3,13 @@ package sdk
 import (
 	"errors"
 	"fmt"
-	"time"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wuyazero/Elastos.ELA.SPV/db"
-	"github.com/wuyazero/Elastos.ELA.SPV/net"
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
 
 	"github.com/wuyazero/Elastos.ELA/bloom"
 	"github.com/wuyazero/Elastos.ELA/core"
@@ -20,6 +21,12 @@ import (
 const (
 	MaxRequests       = 100
 	MaxFalsePositives = 7
+
+	// The sync peer is replaced if no sync progress is made in this duration
+	SyncStallTimeout = time.Second * 30
+	// The sync peer is disconnected after failed this many times, before that
+	// it is only replaced if there is a peer with higher score
+	MaxSyncFailures = 3
 )
 
 // The SPV service implementation
@@ -30,6 +37,13 @@ type SPVServiceImpl struct {
 	queue      *RequestQueue
 	getFilter  func() *bloom.Filter
 	fPositives int
+
+	// Time of the last sync progress in unix nano, accessed atomically
+	lastProgress int64
+
+	quit chan struct{}
+	stop sync.Once
+	wg   sync.WaitGroup
 }
 
 // Create a instance of SPV service implementation.
@@ -43,6 +57,11 @@ func NewSPVServiceImpl(client SPVClient, database db.DataStore, getBloomFilter f
 	if err != nil {
 		return nil, err
 	}
+	// Set checkpoints of the network, override them by Blockchain().SetCheckpoints()
+	service.chain.SetCheckpoints(DefaultCheckpoints(client.PeerManager().Magic()))
+	if len(service.chain.Checkpoints()) == 0 {
+		log.Warn("No checkpoints of network ", client.PeerManager().Magic(), ", headers are not checked against checkpoints")
+	}
 	// Initialize local peer height
 	service.updateLocalHeight()
 
@@ -55,6 +74,8 @@ func NewSPVServiceImpl(client SPVClient, database db.DataStore, getBloomFilter f
 	// Set get bloom filter method
 	service.getFilter = getBloomFilter
 
+	service.quit = make(chan struct{})
+
 	return service, nil
 }
 
@@ -65,14 +86,29 @@ func (service *SPVServiceImpl) OnPeerEstablish(peer *net.Peer) {
 
 func (service *SPVServiceImpl) Start() {
 	service.SPVClient.Start()
+	service.wg.Add(1)
 	go service.keepUpdate()
 	log.Info("SPV service started...")
 }
 
+// Stop the service, only the first call takes effect
 func (service *SPVServiceImpl) Stop() {
-	service.stopSyncing()
-	service.chain.Close()
-	log.Info("SPV service stopped...")
+	service.stop.Do(func() {
+		// Stop synchronizing loop
+		close(service.quit)
+		service.wg.Wait()
+
+		// Stop peer to peer network, so no more messages will come
+		service.SPVClient.Stop()
+
+		service.Lock()
+		service.stopSyncing()
+		service.Unlock()
+
+		service.queue.Stop()
+		service.chain.Close()
+		log.Info("SPV service stopped...")
+	})
 }
 
 func (service *SPVServiceImpl) Blockchain() *Blockchain {
@@ -84,35 +120,57 @@ func (service *SPVServiceImpl) BroadCastMessage(message p2p.Message) {
 }
 
 func (service *SPVServiceImpl) keepUpdate() {
+	defer service.wg.Done()
+
 	ticker := time.NewTicker(time.Second * net.InfoUpdateDuration)
 	defer ticker.Stop()
-	for range ticker.C {
-		// Keep synchronizing blocks
-		service.syncBlocks()
+	for {
+		select {
+		case <-ticker.C:
+			// Replace the sync peer if it stalls
+			service.checkSyncStall()
+			// Keep synchronizing blocks
+			service.syncBlocks()
+		case <-service.quit:
+			return
+		}
 	}
 }
 
 func (service *SPVServiceImpl) needSync() bool {
-	bestPeer := service.PeerManager().GetBestPeer()
-	if bestPeer == nil { // no peers connected, return false
+	// The best height of the peers, not the height of the best peer which may lag behind
+	bestHeight := service.PeerManager().BestHeight()
+	if bestHeight == 0 { // no peers connected, return false
 		return false
 	}
 	chainHeight := uint64(service.chain.Height())
 	log.Info("Chain height:", chainHeight)
-	log.Info("Best peer height:", bestPeer.Height())
+	log.Info("Best height:", bestHeight)
 
-	return bestPeer.Height() > chainHeight
+	return bestHeight > chainHeight
 }
 
 func (service *SPVServiceImpl) syncBlocks() {
 	// Check if blockchain need sync
 	if service.needSync() {
+		// Blocks are being downloaded
+		if service.queue.IsRunning() {
+			return
+		}
 		// Check if blockchain is in syncing state
-		if service.chain.IsSyncing() || service.queue.IsRunning() {
+		if service.chain.IsSyncing() {
+			// The blocks of the last inventory are committed but the peers have more,
+			// like blocks announced after it, request blocks again if no progress in a while.
+			// Progress is not marked, so a sync peer not answering is still replaced.
+			last := time.Unix(0, atomic.LoadInt64(&service.lastProgress))
+			if time.Since(last) >= time.Second*net.InfoUpdateDuration {
+				service.requestBlocks()
+			}
 			return
 		}
 		// Set blockchain state to syncing
 		service.chain.SetChainState(SYNCING)
+		service.markProgress()
 		// Request blocks
 		service.requestBlocks()
 	} else {
@@ -142,14 +200,42 @@ func (service *SPVServiceImpl) requestBlocks() {
 	// Request blocks returns a inventory message which contains block hashes
 	request := msg.NewBlocksReq(service.chain.GetBlockLocatorHashes(), Uint256{})
 
-	go syncPeer.Send(request)
+	syncPeer.Send(request)
+}
+
+// Record the time of sync progress, like receiving blocks or transactions from the sync peer
+func (service *SPVServiceImpl) markProgress() {
+	atomic.StoreInt64(&service.lastProgress, time.Now().UnixNano())
+}
+
+// Change the sync peer if it has not made any progress in SyncStallTimeout,
+// a sync peer may keep the connection alive but stop answering requests
+func (service *SPVServiceImpl) checkSyncStall() {
+	service.Lock()
+	defer service.Unlock()
+
+	if !service.chain.IsSyncing() {
+		return
+	}
+	last := time.Unix(0, atomic.LoadInt64(&service.lastProgress))
+	if time.Since(last) < SyncStallTimeout {
+		return
+	}
+	log.Warn("Sync peer stalled for ", time.Since(last), ", change sync peer")
+	service.changeSyncPeerAndRestart()
 }
 
 func (service *SPVServiceImpl) changeSyncPeerAndRestart() {
 	log.Debug("Change sync peer and restart")
-	// Disconnect current sync peer
+	// Lower the score of current sync peer, and disconnect it if it keeps
+	// failing or there is no other peer to sync from
 	syncPeer := service.PeerManager().GetSyncPeer()
-	service.PeerManager().DisconnectPeer(syncPeer)
+	if syncPeer != nil {
+		syncPeer.OnSyncFailed()
+		if syncPeer.SyncFailures() >= MaxSyncFailures || service.PeerManager().PeersCount() <= 1 {
+			service.PeerManager().DisconnectPeerWithReason(syncPeer, "sync peer failed")
+		}
+	}
 
 	service.stopSyncing()
 	// Restart
@@ -160,6 +246,19 @@ func (service *SPVServiceImpl) OnSendRequest(peer *net.Peer, reqType uint8, hash
 	peer.Send(msg.NewDataReq(reqType, hash))
 }
 
+// Return the established peers which have blocks beyond the local chain,
+// block requests are spread across them when syncing
+func (service *SPVServiceImpl) DownloadPeers() []*net.Peer {
+	height := uint64(service.chain.Height())
+	var peers []*net.Peer
+	for _, peer := range service.PeerManager().ConnectedPeers() {
+		if peer.State() == p2p.ESTABLISH && peer.Height() > height {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
 func (service *SPVServiceImpl) OnRequestError(err error) {
 	service.Lock()
 	defer service.Unlock()
@@ -179,16 +278,22 @@ func (service *SPVServiceImpl) OnRequestFinished(pool *FinishedReqPool) {
 	}
 
 	var fPositives int
-	for request, ok := pool.Next(*current); ok; request, ok = pool.Next(request.Block.Header.Hash()) {
+	for request, ok := service.nextFinished(pool, *current); ok; request, ok = service.nextFinished(pool, request.Block.Header.Hash()) {
 		// Try to commit next block
 		reorg, fp, err := service.chain.CommitBlock(request.Block, request.Txs)
 		if err != nil {
 			fmt.Println(err)
+			// The block is on a fork conflicting with the checkpoints
+			if request.Peer != nil && (err == ErrCheckpointMismatch || err == ErrReorgBelowCheckpoint) {
+				service.misbehave(request.Peer, net.OffenseCheckpointMismatch)
+			}
 			service.changeSyncPeerAndRestart()
 			return
 		}
 		// Update local height after block committed
 		service.updateLocalHeight()
+		// Move the download window forward
+		service.queue.OnBlockCommitted(request.BlockHash)
 
 		// If we meet a reorganize, restart sync process
 		if reorg {
@@ -203,6 +308,18 @@ func (service *SPVServiceImpl) OnRequestFinished(pool *FinishedReqPool) {
 	go service.handleFPositive(fPositives)
 }
 
+// Return the next finished block to commit after current, or the first block
+// of a fork from a stored block if none extends current
+func (service *SPVServiceImpl) nextFinished(pool *FinishedReqPool, current Uint256) (*BlockTxsRequest, bool) {
+	if request, ok := pool.Next(current); ok {
+		return request, ok
+	}
+	return pool.NextFork(func(hash Uint256) bool {
+		_, err := service.chain.GetHeader(hash)
+		return err == nil
+	})
+}
+
 func (service *SPVServiceImpl) handleFPositive(fPositives int) {
 	service.fPositives += fPositives
 	if service.fPositives > MaxFalsePositives {
@@ -224,21 +341,30 @@ func (service *SPVServiceImpl) OnInventory(peer *net.Peer, inv *msg.Inventory) e
 
 func (service *SPVServiceImpl) HandleBlockInvMsg(peer *net.Peer, inv *msg.Inventory) error {
 	if !service.chain.IsSyncing() {
-		peer.Disconnect()
+		service.misbehave(peer, net.OffenseUnexpectedBlock)
 		return errors.New("receive inventory message in non syncing mode")
 	}
 
+	// Only the inventories of the sync peer are followed, blocks are
+	// downloaded from other peers in parallel by the request queue
+	if syncPeer := service.PeerManager().GetSyncPeer(); syncPeer != nil && syncPeer.ID() != peer.ID() {
+		log.Debug("Ignore inventory from non sync peer ", peer.ID())
+		return nil
+	}
+
 	// If no more blocks, return
 	if len(inv.Hashes) == 0 {
 		return nil
 	}
 
+	service.markProgress()
+
 	// Put hashes to request queue
 	service.queue.PushHashes(peer, inv.Hashes)
 
 	// Request more blocks
 	locator := []*Uint256{inv.Hashes[len(inv.Hashes)-1]}
-	go peer.Send(msg.NewBlocksReq(locator, Uint256{}))
+	peer.Send(msg.NewBlocksReq(locator, Uint256{}))
 
 	return nil
 }
@@ -250,22 +376,43 @@ func (service *SPVServiceImpl) OnMerkleBlock(peer *net.Peer, block *bloom.Merkle
 	header := block.Header
 	err := service.chain.CheckProofOfWork(header)
 	if err != nil {
+		service.misbehave(peer, net.OffenseInvalidProofOfWork)
+		return err
+	}
+
+	err = service.chain.CheckCheckpoint(header)
+	if err != nil {
+		service.misbehave(peer, net.OffenseCheckpointMismatch)
+		return err
+	}
+
+	err = service.chain.CheckHeaderContext(header)
+	if err != nil {
+		// Our clock may be wrong, do not blame the peer for a header from the future
+		if err != ErrTimeTooNew {
+			service.misbehave(peer, net.OffenseInvalidHeader)
+		}
 		return err
 	}
 
 	txIds, err := bloom.CheckMerkleBlock(*block)
 	if err != nil {
+		service.misbehave(peer, net.OffenseInvalidMerkleBlock)
 		return errors.New("Invalid merkle block received: " + err.Error())
 	}
 
 	if service.chain.IsSyncing() { // When blockchain in syncing mode
-		if service.PeerManager().GetSyncPeer() != nil && service.PeerManager().GetSyncPeer().ID() != peer.ID() {
-			peer.Disconnect()
+		// Blocks are downloaded from many peers, only unrequested blocks from non sync peers are refused
+		if service.PeerManager().GetSyncPeer() != nil && service.PeerManager().GetSyncPeer().ID() != peer.ID() &&
+			!service.queue.InBlockRequestQueue(blockHash) {
+			service.misbehave(peer, net.OffenseNonSyncPeerData)
 			return fmt.Errorf("receive message from non sync peer: %d\n", peer.ID())
 		}
 
+		service.markProgress()
+
 		// Add block to sync queue
-		err = service.queue.OnBlockReceived(block, txIds)
+		err = service.queue.OnBlockReceived(peer, block, txIds)
 		if err != nil {
 			service.changeSyncPeerAndRestart()
 			return err
@@ -284,13 +431,15 @@ func (service *SPVServiceImpl) OnTxn(peer *net.Peer, txn *core.Transaction) erro
 	log.Debug("Receive transaction hash: ", txn.Hash().String())
 
 	if service.chain.IsSyncing() && service.PeerManager().GetSyncPeer() != nil &&
-		service.PeerManager().GetSyncPeer().ID() != peer.ID() {
+		service.PeerManager().GetSyncPeer().ID() != peer.ID() && !service.queue.IsTxRequested(txn.Hash()) {
 
-		peer.Disconnect()
+		service.misbehave(peer, net.OffenseNonSyncPeerData)
 		return fmt.Errorf("receive message from non sync peer: %d\n", peer.ID())
 	}
 
 	if service.chain.IsSyncing() || service.queue.IsRunning() {
+		service.markProgress()
+
 		// Add transaction to queue
 		err := service.queue.OnTxReceived(txn)
 		if err != nil {
@@ -314,10 +463,22 @@ func (service *SPVServiceImpl) OnTxn(peer *net.Peer, txn *core.Transaction) erro
 func (service *SPVServiceImpl) OnNotFound(peer *net.Peer, msg *msg.NotFound) error {
 	log.Debug("Receive not found: ", msg.Hash.String())
 
+	// Try another peer before giving up the sync peer
+	if service.queue.OnNotFound(peer, msg.Hash) {
+		return nil
+	}
+
 	service.changeSyncPeerAndRestart()
 	return nil
 }
 
+// Add ban score to the misbehaving peer and disconnect it
+func (service *SPVServiceImpl) misbehave(peer *net.Peer, offense net.Offense) {
+	if !service.PeerManager().Misbehave(peer, offense) {
+		peer.Disconnect()
+	}
+}
+
 // Update local peer height with current chain height
 func (service *SPVServiceImpl) updateLocalHeight() {
 	service.PeerManager().Local().SetHeight(uint64(service.chain.Height()))
@@ -0,0 +1,99 @@
+package sdk_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
+	"github.com/wuyazero/Elastos.ELA.SPV/sdk/sdktest"
+
+	"github.com/wuyazero/Elastos.ELA/bloom"
+	"github.com/wuyazero/Elastos.ELA.Utility/p2p"
+)
+
+// A SPV client counting how many times it is stopped
+type testClient struct {
+	pm    *net.PeerManager
+	stops int32
+}
+
+func (c *testClient) SetMessageHandler(sdk.SPVMessageHandler) {}
+
+func (c *testClient) Start() {}
+
+func (c *testClient) Stop() { atomic.AddInt32(&c.stops, 1) }
+
+func (c *testClient) SetConnectPeers(addrs []string) {}
+
+func (c *testClient) AddNode(addr string) {}
+
+func (c *testClient) PeerManager() *net.PeerManager { return c.pm }
+
+func TestServiceStopTwice(t *testing.T) {
+	client := &testClient{pm: net.NewPeerManager(new(net.Peer), &net.Config{DisableListen: true})}
+	service, err := sdk.NewSPVServiceImpl(client, sdktest.NewMemStore(), func() *bloom.Filter { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			service.Stop()
+		}()
+	}
+	wg.Wait()
+	service.Stop()
+
+	if stops := atomic.LoadInt32(&client.stops); stops != 1 {
+		t.Errorf("client stopped %d times, expect once", stops)
+	}
+}
+
+func TestSyncStall(t *testing.T) {
+	client := &testClient{pm: net.NewPeerManager(new(net.Peer), &net.Config{DisableListen: true})}
+	service, err := sdk.NewSPVServiceImpl(client, sdktest.NewMemStore(), func() *bloom.Filter { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer service.Stop()
+
+	pm := client.PeerManager()
+	var peers []*net.Peer
+	for i := 0; i < 2; i++ {
+		peer, closePeer := newTestPeer()
+		defer closePeer()
+		peer.SetID(uint64(i + 1))
+		peer.SetHeight(100)
+		peer.SetState(p2p.ESTABLISH)
+		pm.AddPeer(peer)
+		peers = append(peers, peer)
+	}
+	pm.SetSyncPeer(peers[0])
+	service.Blockchain().SetChainState(sdk.SYNCING)
+
+	// The sync peer is kept while making progress
+	sdk.SetLastProgress(service, time.Now())
+	sdk.CheckSyncStall(service)
+	if pm.GetSyncPeer() != peers[0] {
+		t.Fatal("sync peer making progress replaced")
+	}
+
+	// The stalled sync peer is replaced by the other peer
+	sdk.SetLastProgress(service, time.Now().Add(-sdk.SyncStallTimeout-time.Second))
+	sdk.CheckSyncStall(service)
+	if syncPeer := pm.GetSyncPeer(); syncPeer != peers[1] {
+		t.Errorf("sync peer %v after stalled, expect peer 2", syncPeer)
+	}
+	if failures := peers[0].SyncFailures(); failures != 1 {
+		t.Errorf("stalled sync peer failed %d times, expect 1", failures)
+	}
+	if peers[0].State() == p2p.INACTIVITY {
+		t.Error("stalled sync peer disconnected before failed MaxSyncFailures times")
+	}
+}
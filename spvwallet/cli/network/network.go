@@ -0,0 +1,221 @@
+package network
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
+	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/rpc"
+
+	"github.com/urfave/cli"
+)
+
+func showStats() error {
+	stats, err := rpc.GetClient().GetNetStats()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("TOTAL TRAFFIC")
+	showTraffic(stats.Traffic)
+
+	for _, peer := range stats.Peers {
+		fmt.Println()
+		fmt.Printf("PEER %d %s %s, HEIGHT %d, RTT %s\n",
+			peer.ID, peer.Addr, peer.Direction, peer.Height, peer.RTT)
+		if peer.SyncPeer {
+			fmt.Println("SYNC PEER")
+		}
+		fmt.Println("SCORE", peer.Score)
+		showTraffic(peer.Traffic)
+	}
+
+	return nil
+}
+
+func showPeers() error {
+	peers, err := rpc.GetClient().GetPeerInfo()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	for _, peer := range peers {
+		syncPeer := ""
+		if peer.SyncPeer {
+			syncPeer = ", SYNC PEER"
+		}
+		fmt.Printf("PEER %d %s %s, STATE %s, VERSION %d, HEIGHT %d, BAN SCORE %d%s\n",
+			peer.ID, peer.Addr, peer.Direction, peer.State, peer.Version, peer.Height, peer.BanScore, syncPeer)
+		fmt.Println("\t", peer.Score)
+	}
+	return nil
+}
+
+func showBanned() error {
+	banned, err := rpc.GetClient().ListBanned()
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(banned, func(i, j int) bool { return banned[i].Host < banned[j].Host })
+	for _, ban := range banned {
+		fmt.Printf("%s UNTIL %s, REASON %s\n", ban.Host, ban.Until.Format(time.RFC3339), ban.Reason)
+	}
+	return nil
+}
+
+func showTraffic(traffic net.TrafficStats) {
+	// print header
+	fmt.Printf("%-12s %10s %14s %10s %14s\n", "COMMAND", "MSGS IN", "BYTES IN", "MSGS OUT", "BYTES OUT")
+	fmt.Println(strings.Repeat("-", 12), strings.Repeat("-", 10), strings.Repeat("-", 14),
+		strings.Repeat("-", 10), strings.Repeat("-", 14))
+
+	cmds := make([]string, 0, len(traffic.Commands))
+	for cmd := range traffic.Commands {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+
+	var format = "%-12s %10d %14d %10d %14d\n"
+	for _, cmd := range cmds {
+		s := traffic.Commands[cmd]
+		fmt.Printf(format, cmd, s.MsgsRecv, s.BytesRecv, s.MsgsSent, s.BytesSent)
+	}
+	t := traffic.Total
+	fmt.Printf(format, "TOTAL", t.MsgsRecv, t.BytesRecv, t.MsgsSent, t.BytesSent)
+}
+
+func networkAction(context *cli.Context) {
+	if context.NumFlags() == 0 {
+		cli.ShowSubcommandHelp(context)
+		os.Exit(0)
+	}
+
+	// show traffic statistics
+	if context.Bool("stats") {
+		if err := showStats(); err != nil {
+			fmt.Println("error: show network stats failed,", err)
+			cli.ShowCommandHelpAndExit(context, "stats", 2)
+		}
+		return
+	}
+
+	// show connected peers
+	if context.Bool("peers") {
+		if err := showPeers(); err != nil {
+			fmt.Println("error: show peers failed,", err)
+			cli.ShowCommandHelpAndExit(context, "peers", 3)
+		}
+		return
+	}
+
+	// connect a node
+	if addr := context.String("addnode"); addr != "" {
+		if err := rpc.GetClient().AddNode(addr); err != nil {
+			fmt.Println("error: add node failed,", err)
+			cli.ShowCommandHelpAndExit(context, "addnode", 4)
+		}
+		return
+	}
+
+	// disconnect a node
+	if node := context.String("disconnect"); node != "" {
+		if err := rpc.GetClient().DisconnectNode(node); err != nil {
+			fmt.Println("error: disconnect node failed,", err)
+			cli.ShowCommandHelpAndExit(context, "disconnect", 5)
+		}
+		return
+	}
+
+	// ban a node
+	if addr := context.String("ban"); addr != "" {
+		if err := rpc.GetClient().Ban(addr, context.Int64("bantime")); err != nil {
+			fmt.Println("error: ban node failed,", err)
+			cli.ShowCommandHelpAndExit(context, "ban", 6)
+		}
+		return
+	}
+
+	// lift the ban of a node
+	if addr := context.String("unban"); addr != "" {
+		if err := rpc.GetClient().Unban(addr); err != nil {
+			fmt.Println("error: unban node failed,", err)
+			cli.ShowCommandHelpAndExit(context, "unban", 7)
+		}
+		return
+	}
+
+	// show banned nodes
+	if context.Bool("listbanned") {
+		if err := showBanned(); err != nil {
+			fmt.Println("error: list banned nodes failed,", err)
+			cli.ShowCommandHelpAndExit(context, "listbanned", 8)
+		}
+		return
+	}
+
+	// lift all bans
+	if context.Bool("clearbanned") {
+		if err := rpc.GetClient().ClearBanned(); err != nil {
+			fmt.Println("error: clear banned nodes failed,", err)
+			cli.ShowCommandHelpAndExit(context, "clearbanned", 9)
+		}
+		return
+	}
+}
+
+func NewCommand() cli.Command {
+	return cli.Command{
+		Name:        "network",
+		ShortName:   "n",
+		Usage:       "network [command] [args]",
+		Description: "commands to show the peer to peer network status of the running SPV service",
+		ArgsUsage:   "[args]",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "stats, s",
+				Usage: "show messages and bytes sent and received by command, in total and of each peer",
+			},
+			cli.BoolFlag{
+				Name:  "peers, p",
+				Usage: "show connected peers and their sync peer scores",
+			},
+			cli.StringFlag{
+				Name:  "addnode, a",
+				Usage: "connect the node on the address and add it into the address book",
+			},
+			cli.StringFlag{
+				Name:  "disconnect, d",
+				Usage: "disconnect the node by peer ID, or all peers on the address",
+			},
+			cli.StringFlag{
+				Name:  "ban, b",
+				Usage: "ban the host of the address and disconnect the peers on it",
+			},
+			cli.Int64Flag{
+				Name:  "bantime",
+				Usage: "ban time in seconds used with --ban, 0 for the default ban time",
+			},
+			cli.StringFlag{
+				Name:  "unban, u",
+				Usage: "lift the ban of the host of the address",
+			},
+			cli.BoolFlag{
+				Name:  "listbanned, l",
+				Usage: "show banned hosts",
+			},
+			cli.BoolFlag{
+				Name:  "clearbanned",
+				Usage: "lift all bans",
+			},
+		},
+		Action: networkAction,
+		OnUsageError: func(c *cli.Context, err error, subCommand bool) error {
+			return cli.NewExitError(err, 1)
+		},
+	}
+}
@@ -14,8 +14,17 @@ const (
 var config *Config // The single instance of config
 
 type Config struct {
-	PrintLevel uint8
-	SeedList   []string
+	PrintLevel   uint8
+	SeedList     []string
+	DNSSeeds     []string
+	ConnectPeers []string
+	// Known good blocks the headers are checked against, there are no built in checkpoints
+	Checkpoints []Checkpoint
+}
+
+type Checkpoint struct {
+	Height uint32
+	Hash   string
 }
 
 func (config *Config) readConfigFile() error {
@@ -33,6 +42,17 @@ func (config *Config) readConfigFile() error {
 	return nil
 }
 
+// Read the config file again, the loaded values are replaced only if it succeeds
+func Reload() (*Config, error) {
+	newConfig := new(Config)
+	err := newConfig.readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	config = newConfig
+	return config, nil
+}
+
 func Values() *Config {
 	if config == nil {
 		config = new(Config)
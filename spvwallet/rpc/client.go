@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"io/ioutil"
 	"errors"
+	"fmt"
 
 	. "github.com/wuyazero/Elastos.ELA/core"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
 	"encoding/hex"
 )
 
@@ -47,6 +49,106 @@ func (client *Client) SendTransaction(tx *Transaction) error {
 	return nil
 }
 
+func (client *Client) GetNetStats() (*net.NetStats, error) {
+	resp := client.send(
+		&Req{
+			Method: "getnetstats",
+			Params: []interface{}{},
+		},
+	)
+	if resp.Code != 0 {
+		return nil, errors.New(fmt.Sprint(resp.Result))
+	}
+
+	var stats net.NetStats
+	err := decodeResult(resp.Result, &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+func (client *Client) GetPeerInfo() ([]net.PeerStats, error) {
+	resp := client.send(
+		&Req{
+			Method: "getpeerinfo",
+			Params: []interface{}{},
+		},
+	)
+	if resp.Code != 0 {
+		return nil, errors.New(fmt.Sprint(resp.Result))
+	}
+
+	var peers []net.PeerStats
+	err := decodeResult(resp.Result, &peers)
+	if err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+func (client *Client) AddNode(addr string) error {
+	return client.call("addnode", addr)
+}
+
+// Disconnect the node by peer ID or address
+func (client *Client) DisconnectNode(node string) error {
+	return client.call("disconnectnode", node)
+}
+
+// Ban the node for the ban time in seconds, zero for the default ban time
+func (client *Client) Ban(addr string, banTime int64) error {
+	return client.call("setban", addr, "add", banTime)
+}
+
+func (client *Client) Unban(addr string) error {
+	return client.call("setban", addr, "remove")
+}
+
+func (client *Client) ListBanned() ([]net.BannedHost, error) {
+	resp := client.send(
+		&Req{
+			Method: "listbanned",
+			Params: []interface{}{},
+		},
+	)
+	if resp.Code != 0 {
+		return nil, errors.New(fmt.Sprint(resp.Result))
+	}
+
+	var banned []net.BannedHost
+	err := decodeResult(resp.Result, &banned)
+	if err != nil {
+		return nil, err
+	}
+	return banned, nil
+}
+
+func (client *Client) ClearBanned() error {
+	return client.call("clearbanned")
+}
+
+// Call a method which returns only a message
+func (client *Client) call(method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	resp := client.send(&Req{Method: method, Params: params})
+	if resp.Code != 0 {
+		return errors.New(fmt.Sprint(resp.Result))
+	}
+	return nil
+}
+
+// Decode the JSON result of a response into the given value
+func decodeResult(result interface{}, value interface{}) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
 func (client *Client) send(req *Req) (ret Resp) {
 	data, err := json.Marshal(req)
 	if err != nil {
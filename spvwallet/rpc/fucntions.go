@@ -3,6 +3,7 @@ package rpc
 import (
 	"bytes"
 	"encoding/hex"
+	"time"
 
 	. "github.com/wuyazero/Elastos.ELA/core"
 )
@@ -43,3 +44,79 @@ func (server *Server) SendTransaction(req Req) Resp {
 	}
 	return Success(tx.Hash().String())
 }
+
+func (server *Server) GetNetStats(req Req) Resp {
+	return Success(server.handler.GetNetStats())
+}
+
+func (server *Server) GetPeerInfo(req Req) Resp {
+	return Success(server.handler.GetPeerInfo())
+}
+
+// Params: address
+func (server *Server) AddNode(req Req) Resp {
+	addr, ok := stringParam(req, 0)
+	if !ok {
+		return InvalidParameter
+	}
+	err := server.handler.AddNode(addr)
+	if err != nil {
+		return FunctionError(err.Error())
+	}
+	return Success("Node added")
+}
+
+// Params: peer ID or address
+func (server *Server) DisconnectNode(req Req) Resp {
+	node, ok := stringParam(req, 0)
+	if !ok {
+		return InvalidParameter
+	}
+	err := server.handler.DisconnectNode(node)
+	if err != nil {
+		return FunctionError(err.Error())
+	}
+	return Success("Node disconnected")
+}
+
+// Params: address, "add" or "remove", optional ban time in seconds
+func (server *Server) SetBan(req Req) Resp {
+	addr, ok := stringParam(req, 0)
+	if !ok {
+		return InvalidParameter
+	}
+	command, ok := stringParam(req, 1)
+	if !ok || (command != "add" && command != "remove") {
+		return InvalidParameter
+	}
+	var banTime float64
+	if len(req.Params) > 2 {
+		banTime, ok = req.Params[2].(float64)
+		if !ok || banTime < 0 {
+			return InvalidParameter
+		}
+	}
+	server.handler.SetBan(addr, command == "add", time.Duration(banTime)*time.Second)
+	if command == "add" {
+		return Success("Node banned")
+	}
+	return Success("Node unbanned")
+}
+
+func (server *Server) ListBanned(req Req) Resp {
+	return Success(server.handler.ListBanned())
+}
+
+func (server *Server) ClearBanned(req Req) Resp {
+	server.handler.ClearBanned()
+	return Success("Ban list cleared")
+}
+
+// Return the string parameter at the index
+func stringParam(req Req, index int) (string, bool) {
+	if len(req.Params) <= index {
+		return "", false
+	}
+	param, ok := req.Params[index].(string)
+	return param, ok && len(param) > 0
+}
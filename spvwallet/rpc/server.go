@@ -5,14 +5,23 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"time"
 
 	. "github.com/wuyazero/Elastos.ELA/core"
 	"github.com/wuyazero/Elastos.ELA.SPV/log"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
 )
 
 type RequestHandler interface {
 	NotifyNewAddress(hash []byte) error
 	SendTransaction(Transaction) error
+	GetNetStats() net.NetStats
+	GetPeerInfo() []net.PeerStats
+	AddNode(addr string) error
+	DisconnectNode(node string) error
+	SetBan(addr string, ban bool, duration time.Duration)
+	ListBanned() []net.BannedHost
+	ClearBanned()
 }
 
 func InitServer(handler RequestHandler) *Server {
@@ -21,6 +30,13 @@ func InitServer(handler RequestHandler) *Server {
 	server.methods = map[string]func(Req) Resp{
 		"notifynewaddress": server.NotifyNewAddress,
 		"sendtransaction":  server.SendTransaction,
+		"getnetstats":      server.GetNetStats,
+		"getpeerinfo":      server.GetPeerInfo,
+		"addnode":          server.AddNode,
+		"disconnectnode":   server.DisconnectNode,
+		"setban":           server.SetBan,
+		"listbanned":       server.ListBanned,
+		"clearbanned":      server.ClearBanned,
 	}
 	server.handler = handler
 	http.HandleFunc("/spvwallet/", server.handle)
@@ -1,9 +1,13 @@
 package spvwallet
 
 import (
+	"errors"
+	"strconv"
 	"sync"
+	"time"
 
 	. "github.com/wuyazero/Elastos.ELA.SPV/db"
+	"github.com/wuyazero/Elastos.ELA.SPV/net"
 	"github.com/wuyazero/Elastos.ELA.SPV/sdk"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/db"
 	"github.com/wuyazero/Elastos.ELA.SPV/spvwallet/rpc"
@@ -13,7 +17,7 @@ import (
 	. "github.com/wuyazero/Elastos.ELA.Utility/common"
 )
 
-func Init(clientId uint64, seeds []string) (*SPVWallet, error) {
+func Init(clientId uint64, seeds, dnsSeeds, connectPeers []string) (*SPVWallet, error) {
 	var err error
 	wallet := new(SPVWallet)
 
@@ -30,11 +34,18 @@ func Init(clientId uint64, seeds []string) (*SPVWallet, error) {
 	}
 
 	// Initialize P2P network client
-	client, err := sdk.GetSPVClient(sdk.TypeMainNet, clientId, seeds)
+	client, err := sdk.GetSPVClientWithConfig(clientId, &net.Config{
+		Magic:        sdk.MainNetMagic,
+		SeedList:     seeds,
+		DNSSeeds:     dnsSeeds,
+		ConnectPeers: connectPeers,
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	wallet.client = client
+
 	// Initialize spv service
 	wallet.SPVService, err = sdk.GetSPVService(client, wallet, wallet.getBloomFilter)
 	if err != nil {
@@ -50,6 +61,7 @@ func Init(clientId uint64, seeds []string) (*SPVWallet, error) {
 type SPVWallet struct {
 	sync.Mutex
 	sdk.SPVService
+	client    sdk.SPVClient
 	rpcServer *rpc.Server
 	headers   db.Headers
 	dataStore db.DataStore
@@ -66,6 +78,16 @@ func (wallet *SPVWallet) Stop() {
 	wallet.rpcServer.Close()
 }
 
+// Connect only to the given peers, peers not in the list will be disconnected
+func (wallet *SPVWallet) SetConnectPeers(addrs []string) {
+	wallet.client.SetConnectPeers(addrs)
+}
+
+// Replace the checkpoints of the blockchain, the headers conflicting with them will be refused
+func (wallet *SPVWallet) SetCheckpoints(checkpoints []sdk.Checkpoint) {
+	wallet.Blockchain().SetCheckpoints(checkpoints)
+}
+
 func (wallet *SPVWallet) Headers() db.Headers {
 	return wallet.headers
 }
@@ -194,6 +216,55 @@ func (wallet *SPVWallet) SendTransaction(tx Transaction) error {
 	return nil
 }
 
+func (wallet *SPVWallet) GetNetStats() net.NetStats {
+	return wallet.PeerManager().Stats()
+}
+
+func (wallet *SPVWallet) GetPeerInfo() []net.PeerStats {
+	return wallet.PeerManager().Stats().Peers
+}
+
+func (wallet *SPVWallet) AddNode(addr string) error {
+	if wallet.PeerManager().BanList().IsBanned(addr) {
+		return errors.New("node is banned, unban it first")
+	}
+	wallet.client.AddNode(addr)
+	return nil
+}
+
+func (wallet *SPVWallet) DisconnectNode(node string) error {
+	// The node is a peer ID or an address
+	if id, err := strconv.ParseUint(node, 10, 64); err == nil {
+		if !wallet.PeerManager().DisconnectPeerByID(id, "disconnected by rpc") {
+			return errors.New("peer not found")
+		}
+		return nil
+	}
+	if wallet.PeerManager().DisconnectHost(node, "disconnected by rpc") == 0 {
+		return errors.New("peer not found")
+	}
+	return nil
+}
+
+func (wallet *SPVWallet) SetBan(addr string, ban bool, duration time.Duration) {
+	if !ban {
+		wallet.PeerManager().Unban(addr)
+		return
+	}
+	if duration <= 0 {
+		duration = net.DefaultBanDuration
+	}
+	wallet.PeerManager().Ban(addr, duration, "banned by rpc")
+}
+
+func (wallet *SPVWallet) ListBanned() []net.BannedHost {
+	return wallet.PeerManager().BanList().Banned()
+}
+
+func (wallet *SPVWallet) ClearBanned() {
+	wallet.PeerManager().BanList().Clear()
+}
+
 func (wallet *SPVWallet) getAddrFilter() *sdk.AddrFilter {
 	if wallet.filter == nil {
 		wallet.loadAddrFilter()